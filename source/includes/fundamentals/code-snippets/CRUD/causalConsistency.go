@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+type Item struct {
+	SKU      string `bson:"sku"`
+	Quantity int    `bson:"quantity"`
+}
+
+func main() {
+	// Reading from secondaries requires a replica set with at least one
+	// secondary
+	var uri string
+	if uri = os.Getenv("MONGODB_URI"); uri == "" {
+		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/")
+	}
+
+	// begin client
+	// Causal consistency guarantees hold only for operations that use
+	// majority read and write concerns
+	clientOpts := options.Client().
+		ApplyURI(uri).
+		SetReadConcern(readconcern.Majority()).
+		SetWriteConcern(writeconcern.New(writeconcern.WMajority())).
+		SetReadPreference(readpref.Secondary())
+	client, err := mongo.Connect(context.TODO(), clientOpts)
+	// end client
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err = client.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+
+	coll := client.Database("inventory").Collection("items")
+	coll.Drop(context.TODO())
+	if _, err := coll.InsertOne(context.TODO(), Item{SKU: "abc123", Quantity: 0}); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Read your own writes:")
+	{
+		// begin read your writes
+		sessOpts := options.Session().SetCausalConsistency(true)
+		session, err := client.StartSession(sessOpts)
+		if err != nil {
+			panic(err)
+		}
+		defer session.EndSession(context.TODO())
+
+		err = mongo.WithSession(context.TODO(), session, func(ctx mongo.SessionContext) error {
+			for i := 1; i <= 20; i++ {
+				filter := bson.D{{"sku", "abc123"}}
+				update := bson.D{{"$set", bson.D{{"quantity", i}}}}
+				if _, err := coll.UpdateOne(ctx, filter, update); err != nil {
+					return err
+				}
+
+				// The session sends the operation time of the update as
+				// afterClusterTime, so the secondary waits until it has
+				// applied the update before it answers
+				var item Item
+				if err := coll.FindOne(ctx, filter).Decode(&item); err != nil {
+					return err
+				}
+				if item.Quantity != i {
+					return fmt.Errorf("read quantity %d after writing %d", item.Quantity, i)
+				}
+			}
+			return nil
+		})
+		// end read your writes
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Every read from a secondary returned the preceding write")
+	}
+
+	fmt.Println("\nMonotonic reads:")
+	{
+		// A writer on the primary keeps incrementing the quantity while a
+		// causally consistent reader reads from any secondary. Each read
+		// returns data at least as new as the previous read.
+		done := make(chan error)
+		go func() {
+			for i := 0; i < 200; i++ {
+				update := bson.D{{"$inc", bson.D{{"quantity", 1}}}}
+				if _, err := coll.UpdateOne(context.TODO(), bson.D{{"sku", "abc123"}}, update); err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}()
+
+		session, err := client.StartSession(options.Session().SetCausalConsistency(true))
+		if err != nil {
+			panic(err)
+		}
+		defer session.EndSession(context.TODO())
+
+		reads, last := 0, -1
+		err = mongo.WithSession(context.TODO(), session, func(ctx mongo.SessionContext) error {
+			for {
+				select {
+				case err := <-done:
+					return err
+				default:
+				}
+				var item Item
+				if err := coll.FindOne(ctx, bson.D{{"sku", "abc123"}}).Decode(&item); err != nil {
+					return err
+				}
+				if item.Quantity < last {
+					return fmt.Errorf("read quantity %d after reading %d", item.Quantity, last)
+				}
+				last = item.Quantity
+				reads++
+			}
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%d reads never went back in time\n", reads)
+	}
+
+	fmt.Println("\nPass causality between clients:")
+	{
+		// A second client stands for another process, such as a service
+		// that receives a request after the first client writes
+		other, err := mongo.Connect(context.TODO(), clientOpts)
+		if err != nil {
+			panic(err)
+		}
+		defer other.Disconnect(context.TODO())
+		otherColl := other.Database("inventory").Collection("items")
+
+		// begin advance
+		writer, err := client.StartSession(options.Session().SetCausalConsistency(true))
+		if err != nil {
+			panic(err)
+		}
+		defer writer.EndSession(context.TODO())
+
+		err = mongo.WithSession(context.TODO(), writer, func(ctx mongo.SessionContext) error {
+			_, err := coll.InsertOne(ctx, Item{SKU: "def456", Quantity: 7})
+			return err
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		// Send these values to the reader along with the request, for
+		// example in a message or an HTTP header
+		clusterTime := writer.ClusterTime()
+		operationTime := writer.OperationTime()
+
+		reader, err := other.StartSession(options.Session().SetCausalConsistency(true))
+		if err != nil {
+			panic(err)
+		}
+		defer reader.EndSession(context.TODO())
+
+		// The reader's operations now happen after the writer's insert
+		if err := reader.AdvanceClusterTime(clusterTime); err != nil {
+			panic(err)
+		}
+		if err := reader.AdvanceOperationTime(operationTime); err != nil {
+			panic(err)
+		}
+
+		var item Item
+		err = mongo.WithSession(context.TODO(), reader, func(ctx mongo.SessionContext) error {
+			return otherColl.FindOne(ctx, bson.D{{"sku", "def456"}}).Decode(&item)
+		})
+		// end advance
+		if err != nil {
+			log.Fatalf("the reader didn't see the writer's insert: %v", err)
+		}
+		fmt.Printf("The reader on the other client read %+v\n", item)
+	}
+}
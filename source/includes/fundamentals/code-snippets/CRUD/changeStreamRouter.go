@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// begin event
+// Namespace is the database and collection in which a change occurred.
+type Namespace struct {
+	DB   string `bson:"db"`
+	Coll string `bson:"coll"`
+}
+
+// Event is a change event whose fullDocument and fullDocumentBeforeChange
+// fields are decoded into the struct registered for the namespace.
+type Event struct {
+	OperationType     string    `bson:"operationType"`
+	Namespace         Namespace `bson:"ns"`
+	DocumentKey       bson.Raw  `bson:"documentKey"`
+	UpdateDescription *struct {
+		UpdatedFields bson.Raw `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+
+	// FullDocument and FullDocumentBeforeChange are pointers to the struct
+	// registered for the namespace, or nil if the event doesn't include the
+	// document
+	FullDocument             interface{} `bson:"-"`
+	FullDocumentBeforeChange interface{} `bson:"-"`
+}
+
+// HandlerFunc handles a routed change event.
+type HandlerFunc func(ctx context.Context, event Event) error
+
+// end event
+
+type route struct {
+	db, coll, operationType string
+	handler                 HandlerFunc
+}
+
+// Router dispatches the events of a database or client change stream to the
+// handlers registered for their namespace and operation type.
+type Router struct {
+	types  map[Namespace]reflect.Type
+	routes []route
+}
+
+func NewRouter() *Router {
+	return &Router{types: make(map[Namespace]reflect.Type)}
+}
+
+// begin register
+// RegisterType sets the struct into which the router decodes the full
+// documents of the given collection. Pass a value of the struct type, such
+// as Order{}.
+func (r *Router) RegisterType(db, coll string, example interface{}) {
+	r.types[Namespace{db, coll}] = reflect.TypeOf(example)
+}
+
+// Handle registers a handler for the events that match db, coll, and
+// operationType. An empty string matches any value. The router calls every
+// matching handler in the order in which they were registered.
+func (r *Router) Handle(db, coll, operationType string, handler HandlerFunc) {
+	r.routes = append(r.routes, route{db, coll, operationType, handler})
+}
+
+// end register
+
+// Pipeline returns a $match stage that passes only the events of registered
+// namespaces, so that the server doesn't send events that no handler uses.
+func (r *Router) Pipeline() mongo.Pipeline {
+	var or bson.A
+	for _, rt := range r.routes {
+		cond := bson.D{}
+		if rt.db != "" {
+			cond = append(cond, bson.E{"ns.db", rt.db})
+		}
+		if rt.coll != "" {
+			cond = append(cond, bson.E{"ns.coll", rt.coll})
+		}
+		if rt.operationType != "" {
+			cond = append(cond, bson.E{"operationType", rt.operationType})
+		}
+		if len(cond) == 0 {
+			// A route that matches everything makes the filter pointless
+			return mongo.Pipeline{}
+		}
+		or = append(or, cond)
+	}
+	return mongo.Pipeline{{{"$match", bson.D{{"$or", or}}}}}
+}
+
+// begin dispatch
+// Dispatch decodes a change event and calls the handlers that match it.
+func (r *Router) Dispatch(ctx context.Context, raw bson.Raw) error {
+	var event Event
+	if err := bson.Unmarshal(raw, &event); err != nil {
+		return err
+	}
+
+	docType, ok := r.types[event.Namespace]
+	if ok {
+		var err error
+		if event.FullDocument, err = decodeAs(raw.Lookup("fullDocument"), docType); err != nil {
+			return fmt.Errorf("decoding fullDocument of %s.%s: %v", event.Namespace.DB, event.Namespace.Coll, err)
+		}
+		if event.FullDocumentBeforeChange, err = decodeAs(raw.Lookup("fullDocumentBeforeChange"), docType); err != nil {
+			return fmt.Errorf("decoding fullDocumentBeforeChange of %s.%s: %v", event.Namespace.DB, event.Namespace.Coll, err)
+		}
+	}
+
+	for _, rt := range r.routes {
+		if matches(rt.db, event.Namespace.DB) && matches(rt.coll, event.Namespace.Coll) &&
+			matches(rt.operationType, event.OperationType) {
+			if err := rt.handler(ctx, event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// end dispatch
+
+// decodeAs decodes an embedded document into a new value of type t and
+// returns a pointer to it, or nil if the field is missing or null.
+func decodeAs(value bson.RawValue, t reflect.Type) (interface{}, error) {
+	if value.Type != bson.TypeEmbeddedDocument {
+		return nil, nil
+	}
+	doc := reflect.New(t).Interface()
+	if err := value.Unmarshal(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func matches(pattern, value string) bool {
+	return pattern == "" || pattern == value
+}
+
+// Run dispatches each event of the change stream until ctx is canceled or a
+// handler returns an error.
+func (r *Router) Run(ctx context.Context, cs *mongo.ChangeStream) error {
+	for cs.Next(ctx) {
+		if err := r.Dispatch(ctx, cs.Current); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return cs.Err()
+}
+
+type Order struct {
+	ID     int32   `bson:"_id"`
+	Item   string  `bson:"item"`
+	Status string  `bson:"status"`
+	Total  float64 `bson:"total"`
+}
+
+type Customer struct {
+	ID    int32  `bson:"_id"`
+	Name  string `bson:"name"`
+	Email string `bson:"email"`
+}
+
+type AuditEntry struct {
+	Action string    `bson:"action"`
+	At     time.Time `bson:"at"`
+}
+
+// received collects the handled events so that main can check them.
+type received struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *received) add(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	line := fmt.Sprintf(format, args...)
+	fmt.Println(line)
+	r.events = append(r.events, line)
+}
+
+func (r *received) waitFor(n int) []string {
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		if len(r.events) >= n {
+			events := append([]string(nil), r.events...)
+			r.mu.Unlock()
+			return events
+		}
+		r.mu.Unlock()
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Fatalf("timed out waiting for %d events, received %v", n, r.events)
+	return nil
+}
+
+func main() {
+	// Change streams require a replica set or sharded cluster. Pre- and
+	// post-images require MongoDB 6.0 or later.
+	var uri string
+	if uri = os.Getenv("MONGODB_URI"); uri == "" {
+		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/")
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err = client.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+
+	shop := client.Database("shop")
+	audit := client.Database("audit")
+	shop.Drop(context.TODO())
+	audit.Drop(context.TODO())
+
+	// begin create collections
+	// The server stores pre- and post-images only for collections that
+	// enable changeStreamPreAndPostImages
+	imagesOpts := options.CreateCollection().
+		SetChangeStreamPreAndPostImages(bson.D{{"enabled", true}})
+	if err := shop.CreateCollection(context.TODO(), "orders", imagesOpts); err != nil {
+		panic(err)
+	}
+	if err := shop.CreateCollection(context.TODO(), "customers"); err != nil {
+		panic(err)
+	}
+	// end create collections
+
+	var got received
+
+	// begin routes
+	router := NewRouter()
+	router.RegisterType("shop", "orders", Order{})
+	router.RegisterType("shop", "customers", Customer{})
+	router.RegisterType("audit", "log", AuditEntry{})
+
+	// The full documents are nil when the event doesn't include them, for
+	// example if the pre-image expired or the document was deleted before
+	// the update lookup, so the handlers check the type assertions
+	router.Handle("shop", "orders", "insert", func(ctx context.Context, e Event) error {
+		order, ok := e.FullDocument.(*Order)
+		if !ok {
+			log.Printf("skipping order insert %s without a full document", e.DocumentKey)
+			return nil
+		}
+		got.add("new order %d: %s for %.2f", order.ID, order.Item, order.Total)
+		return nil
+	})
+	router.Handle("shop", "orders", "update", func(ctx context.Context, e Event) error {
+		before, hasBefore := e.FullDocumentBeforeChange.(*Order)
+		after, hasAfter := e.FullDocument.(*Order)
+		if !hasBefore || !hasAfter {
+			if e.UpdateDescription == nil {
+				log.Printf("skipping order update %s without images or an update description", e.DocumentKey)
+				return nil
+			}
+			got.add("order %s updated: %s", e.DocumentKey, e.UpdateDescription.UpdatedFields)
+			return nil
+		}
+		got.add("order %d status: %s -> %s", after.ID, before.Status, after.Status)
+		return nil
+	})
+	router.Handle("shop", "orders", "delete", func(ctx context.Context, e Event) error {
+		// A delete event has no post-image, but has a pre-image
+		before, ok := e.FullDocumentBeforeChange.(*Order)
+		if !ok {
+			got.add("order %s deleted", e.DocumentKey)
+			return nil
+		}
+		got.add("order %d deleted, it was %s", before.ID, before.Status)
+		return nil
+	})
+	// Delete and drop events have no full document, so only the operations
+	// that write a customer are routed to this handler
+	customerChanged := func(ctx context.Context, e Event) error {
+		// The customers collection doesn't store pre-images, so
+		// FullDocumentBeforeChange is always nil
+		customer, ok := e.FullDocument.(*Customer)
+		if !ok {
+			log.Printf("skipping customer %s %s without a full document", e.OperationType, e.DocumentKey)
+			return nil
+		}
+		got.add("customer %s: %+v, has pre-image: %t", e.OperationType, *customer, e.FullDocumentBeforeChange != nil)
+		return nil
+	}
+	for _, op := range []string{"insert", "update", "replace"} {
+		router.Handle("shop", "customers", op, customerChanged)
+	}
+	router.Handle("audit", "", "insert", func(ctx context.Context, e Event) error {
+		entry, ok := e.FullDocument.(*AuditEntry)
+		if !ok {
+			log.Printf("skipping audit entry %s without a full document", e.DocumentKey)
+			return nil
+		}
+		got.add("audit entry in %s: %s", e.Namespace.Coll, entry.Action)
+		return nil
+	})
+	// end routes
+
+	// begin watch client
+	// UpdateLookup returns the current document for update events, and
+	// WhenAvailable returns the pre-image if the collection stores one
+	opts := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetFullDocumentBeforeChange(options.WhenAvailable)
+	cs, err := client.Watch(context.TODO(), router.Pipeline(), opts)
+	if err != nil {
+		panic(err)
+	}
+	defer cs.Close(context.TODO())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- router.Run(ctx, cs) }()
+	// end watch client
+
+	orders := shop.Collection("orders")
+	customers := shop.Collection("customers")
+	ops := []struct {
+		// events is the number of events that the operation sends to a
+		// handler
+		events int
+		run    func() error
+	}{
+		{1, func() error {
+			_, err := orders.InsertOne(context.TODO(), Order{1, "kettle", "pending", 24.5})
+			return err
+		}},
+		{1, func() error {
+			_, err := orders.UpdateByID(context.TODO(), 1, bson.D{{"$set", bson.D{{"status", "shipped"}}}})
+			return err
+		}},
+		{1, func() error {
+			_, err := customers.InsertOne(context.TODO(), Customer{7, "Ada", "ada@example.com"})
+			return err
+		}},
+		{1, func() error {
+			_, err := customers.UpdateByID(context.TODO(), 7, bson.D{{"$set", bson.D{{"email", "ada@example.org"}}}})
+			return err
+		}},
+		{1, func() error {
+			_, err := customers.ReplaceOne(context.TODO(), bson.D{{"_id", 7}}, Customer{7, "Ada L.", "ada@example.org"})
+			return err
+		}},
+		{0, func() error {
+			// No customers route matches delete events, so the pipeline
+			// filters out the event
+			_, err := customers.DeleteOne(context.TODO(), bson.D{{"_id", 7}})
+			return err
+		}},
+		{0, func() error {
+			// No handler matches this namespace, so the pipeline filters
+			// out the event
+			_, err := shop.Collection("carts").InsertOne(context.TODO(), bson.D{{"_id", 1}})
+			return err
+		}},
+		{1, func() error {
+			_, err := audit.Collection("log").InsertOne(context.TODO(), AuditEntry{"shipped order 1", time.Now()})
+			return err
+		}},
+		{1, func() error {
+			_, err := orders.DeleteOne(context.TODO(), bson.D{{"_id", 1}})
+			return err
+		}},
+	}
+	routed := 0
+	for _, op := range ops {
+		if err := op.run(); err != nil {
+			panic(err)
+		}
+		// UpdateLookup reads the current document when the stream returns
+		// the event, not when the write happened, so wait for the events of
+		// each operation before the next write changes the document
+		routed += op.events
+		got.waitFor(routed)
+	}
+
+	want := []string{
+		"new order 1: kettle for 24.50",
+		"order 1 status: pending -> shipped",
+		"customer insert: {ID:7 Name:Ada Email:ada@example.com}, has pre-image: false",
+		"customer update: {ID:7 Name:Ada Email:ada@example.org}, has pre-image: false",
+		"customer replace: {ID:7 Name:Ada L. Email:ada@example.org}, has pre-image: false",
+		"audit entry in log: shipped order 1",
+		"order 1 deleted, it was shipped",
+	}
+	events := got.waitFor(len(want))
+	cancel()
+	if err := <-done; err != nil {
+		panic(err)
+	}
+
+	if !reflect.DeepEqual(events, want) {
+		log.Fatalf("routed events don't match\ngot:  %q\nwant: %q", events, want)
+	}
+	fmt.Println("\nEvery event reached the handler for its namespace and operation type")
+}
@@ -0,0 +1,160 @@
+//go:build go1.23
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"includes/fundamentals/code-snippets/cursoriter"
+)
+
+type MyStruct struct {
+	MyProperty string
+}
+
+func main() {
+	var uri string
+	if uri = os.Getenv("MONGODB_URI"); uri == "" {
+		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/")
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err = client.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+
+	coll := client.Database("db").Collection("sample_data")
+	coll.Drop(context.TODO())
+	docs := []interface{}{
+		MyStruct{MyProperty: "abc"},
+		MyStruct{MyProperty: "def"},
+		MyStruct{MyProperty: "ghi"},
+		MyStruct{MyProperty: "jkl"},
+		MyStruct{MyProperty: "mno"},
+	}
+	if _, err := coll.InsertMany(context.TODO(), docs); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Range over a cursor:")
+	{
+		cursor, err := coll.Find(context.TODO(), bson.D{}, options.Find().SetSort(bson.D{{"myproperty", 1}}))
+		if err != nil {
+			panic(err)
+		}
+
+		// begin range cursor
+		for result, err := range cursoriter.All[MyStruct](context.TODO(), cursor) {
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("%+v\n", result)
+		}
+		// end range cursor
+	}
+
+	fmt.Println("Range over batches:")
+	{
+		// begin range batches
+		opts := options.Find().SetSort(bson.D{{"myproperty", 1}}).SetBatchSize(2)
+		cursor, err := coll.Find(context.TODO(), bson.D{}, opts)
+		if err != nil {
+			panic(err)
+		}
+
+		for batch, err := range cursoriter.Batches[MyStruct](context.TODO(), cursor) {
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("%d documents: %+v\n", len(batch), batch)
+		}
+		// end range batches
+	}
+
+	// The batches follow the batch size of the cursor
+	{
+		opts := options.Find().SetBatchSize(2)
+		cursor, err := coll.Find(context.TODO(), bson.D{}, opts)
+		if err != nil {
+			panic(err)
+		}
+		var sizes []int
+		for batch, err := range cursoriter.Batches[MyStruct](context.TODO(), cursor) {
+			if err != nil {
+				log.Fatal(err)
+			}
+			sizes = append(sizes, len(batch))
+		}
+		if !slices.Equal(sizes, []int{2, 2, 1}) {
+			log.Fatalf("the batches have %v documents, want [2 2 1]", sizes)
+		}
+	}
+
+	fmt.Println("Range over a change stream:")
+	{
+		cs, err := coll.Watch(context.TODO(), mongo.Pipeline{})
+		if err != nil {
+			panic(err)
+		}
+		go func() {
+			for _, property := range []string{"pqr", "stu", "vwx"} {
+				if _, err := coll.InsertOne(context.TODO(), MyStruct{property}); err != nil {
+					panic(err)
+				}
+			}
+		}()
+
+		// begin range changes
+		type event struct {
+			OperationType string   `bson:"operationType"`
+			FullDocument  MyStruct `bson:"fullDocument"`
+		}
+		received := 0
+		for change, err := range cursoriter.All[event](context.TODO(), cs) {
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("%s: %+v\n", change.OperationType, change.FullDocument)
+			if received++; received == 3 {
+				break
+			}
+		}
+		// end range changes
+		if cs.ID() != 0 {
+			log.Fatal("the change stream is still open after the loop")
+		}
+	}
+
+	// A change stream without events ends when the context expires
+	{
+		ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+		defer cancel()
+		cs, err := coll.Watch(ctx, mongo.Pipeline{})
+		if err != nil {
+			panic(err)
+		}
+		var errs []error
+		for _, err := range cursoriter.All[bson.M](ctx, cs) {
+			errs = append(errs, err)
+		}
+		if len(errs) != 1 || !errors.Is(errs[0], context.DeadlineExceeded) {
+			log.Fatalf("the change stream returned %v, want a deadline error", errs)
+		}
+		fmt.Println("The change stream loop ended when the context expired")
+	}
+}
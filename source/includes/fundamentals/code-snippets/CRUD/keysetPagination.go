@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"includes/fundamentals/code-snippets/pagination"
+)
+
+type Course struct {
+	Title      string
+	Enrollment int32
+}
+
+var courseSort = bson.D{{"enrollment", -1}, {"title", 1}}
+
+func mustPaginator(sort bson.D, limit int64) *pagination.Paginator {
+	p, err := pagination.New(sort, limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return p
+}
+
+func titles(docs []bson.Raw) []string {
+	var names []string
+	for _, doc := range docs {
+		var course Course
+		if err := bson.Unmarshal(doc, &course); err != nil {
+			panic(err)
+		}
+		names = append(names, course.Title)
+	}
+	return names
+}
+
+// checkPages pages forward and backward through the courses with every
+// page size, and compares the pages to the results of SetSkip().
+func checkPages(coll *mongo.Collection, filter bson.D) {
+	p := mustPaginator(courseSort, 1)
+	cursor, err := coll.Find(context.TODO(), filter, options.Find().SetSort(p.Sort()))
+	if err != nil {
+		panic(err)
+	}
+	var all []bson.Raw
+	for cursor.Next(context.TODO()) {
+		all = append(all, append(bson.Raw(nil), cursor.Current...))
+	}
+	if err := cursor.Err(); err != nil {
+		panic(err)
+	}
+	want := titles(all)
+
+	for size := 1; size <= len(want)+1; size++ {
+		p := mustPaginator(courseSort, int64(size))
+		var pages [][]string
+		var last *pagination.Page
+		for tok := ""; ; {
+			page, err := p.Find(context.TODO(), coll, filter, tok)
+			if err != nil {
+				panic(err)
+			}
+			if (tok == "") != (page.Prev == "") {
+				log.Fatalf("page size %d, page %d: Prev is %q", size, len(pages)+1, page.Prev)
+			}
+			skipped, err := coll.Find(context.TODO(), filter,
+				options.Find().SetSort(p.Sort()).SetSkip(int64(len(pages)*size)).SetLimit(int64(size)))
+			if err != nil {
+				panic(err)
+			}
+			var skipPage []Course
+			if err = skipped.All(context.TODO(), &skipPage); err != nil {
+				panic(err)
+			}
+			if fmt.Sprint(titles(page.Documents)) != fmt.Sprint(courseTitles(skipPage)) {
+				log.Fatalf("page size %d, page %d: %v, want %v", size, len(pages)+1, titles(page.Documents), courseTitles(skipPage))
+			}
+			pages = append(pages, titles(page.Documents))
+			last = page
+			if page.Next == "" {
+				break
+			}
+			tok = page.Next
+		}
+		if fmt.Sprint(flatten(pages)) != fmt.Sprint(want) {
+			log.Fatalf("page size %d: the pages contain %v, want %v", size, flatten(pages), want)
+		}
+
+		// Read the same pages backward from the last page
+		for i := len(pages) - 2; i >= 0; i-- {
+			page, err := p.Find(context.TODO(), coll, filter, last.Prev)
+			if err != nil {
+				panic(err)
+			}
+			if fmt.Sprint(titles(page.Documents)) != fmt.Sprint(pages[i]) {
+				log.Fatalf("page size %d, page %d backward: %v, want %v", size, i+1, titles(page.Documents), pages[i])
+			}
+			if page.Next == "" || (i == 0) != (page.Prev == "") {
+				log.Fatalf("page size %d, page %d backward: Next is %q and Prev is %q", size, i+1, page.Next, page.Prev)
+			}
+			last = page
+		}
+	}
+	fmt.Printf("Pages of every size match SetSkip() for the filter %v in both directions\n", filter)
+}
+
+func courseTitles(courses []Course) []string {
+	var names []string
+	for _, course := range courses {
+		names = append(names, course.Title)
+	}
+	return names
+}
+
+func flatten(pages [][]string) []string {
+	var all []string
+	for _, page := range pages {
+		all = append(all, page...)
+	}
+	return all
+}
+
+func main() {
+	var uri string
+	if uri = os.Getenv("MONGODB_URI"); uri == "" {
+		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/#environment-variable")
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err = client.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+
+	// begin insertDocs
+	coll := client.Database("db").Collection("courses")
+	courses := []interface{}{
+		Course{Title: "World Fiction", Enrollment: 35},
+		Course{Title: "Abstract Algebra", Enrollment: 60},
+		Course{Title: "Modern Poetry", Enrollment: 12},
+		Course{Title: "Plate Tectonics", Enrollment: 35},
+		Course{Title: "Romantic Era Music", Enrollment: 15},
+		Course{Title: "Concepts in Topology", Enrollment: 35},
+		Course{Title: "Ancient Greece", Enrollment: 100},
+		Course{Title: "Physiology I", Enrollment: 60},
+	}
+
+	result, err := coll.InsertMany(context.TODO(), courses)
+	// end insertDocs
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Number of documents inserted: %d\n", len(result.InsertedIDs))
+
+	// begin paginator
+	p, err := pagination.New(bson.D{{"enrollment", -1}, {"title", 1}}, 3)
+	if err != nil {
+		panic(err)
+	}
+	// end paginator
+
+	fmt.Println("\nNext pages:")
+	var last *pagination.Page
+	{
+		// begin next page
+		var page *pagination.Page
+		var token string
+		for {
+			page, err = p.Find(context.TODO(), coll, bson.D{}, token)
+			if err != nil {
+				panic(err)
+			}
+			for _, doc := range page.Documents {
+				var result Course
+				if err := bson.Unmarshal(doc, &result); err != nil {
+					panic(err)
+				}
+				res, _ := json.Marshal(result)
+				fmt.Println(string(res))
+			}
+			if page.Next == "" {
+				break
+			}
+			fmt.Println("---")
+			token = page.Next
+		}
+		// end next page
+		last = page
+	}
+
+	fmt.Println("\nPrevious page:")
+	{
+		// begin previous page
+		page, err := p.Find(context.TODO(), coll, bson.D{}, last.Prev)
+		if err != nil {
+			panic(err)
+		}
+		for _, doc := range page.Documents {
+			var result Course
+			if err := bson.Unmarshal(doc, &result); err != nil {
+				panic(err)
+			}
+			res, _ := json.Marshal(result)
+			fmt.Println(string(res))
+		}
+		// end previous page
+	}
+
+	checkPages(coll, bson.D{})
+	checkPages(coll, bson.D{{"enrollment", bson.D{{"$lt", 100}}}})
+	checkPages(coll, bson.D{{"title", "Missing"}})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"includes/fundamentals/code-snippets/filter"
+	"includes/fundamentals/code-snippets/update"
+)
+
+type Drink struct {
+	Description string
+	Sizes       []int32 `bson:"sizes,truncate"`
+	Styles      []string
+}
+
+func main() {
+	var uri string
+	if uri = os.Getenv("MONGODB_URI"); uri == "" {
+		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/")
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err = client.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+
+	coll := client.Database("db").Collection("drinks")
+	coll.Drop(context.TODO())
+	drink := Drink{Description: "Matcha Latte", Sizes: []int32{12, 16, 20}, Styles: []string{"iced", "hot", "extra hot"}}
+	if _, err := coll.InsertOne(context.TODO(), drink); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("\nPositional $ Operator:")
+	{
+		// begin positional
+		f := filter.Where("sizes", filter.Lte(16))
+		u, err := update.New(update.Inc(update.Positional("sizes"), -2))
+		if err != nil {
+			panic(err)
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		var updatedDoc Drink
+		err = coll.FindOneAndUpdate(context.TODO(), f, u, opts).Decode(&updatedDoc)
+		if err != nil {
+			panic(err)
+		}
+		// end positional
+		fmt.Printf("%v\n", updatedDoc.Sizes)
+		if updatedDoc.Sizes[0] != 10 || updatedDoc.Sizes[1] != 16 {
+			log.Fatalf("sizes are %v, want [10 16 20]", updatedDoc.Sizes)
+		}
+	}
+
+	fmt.Println("\nCombined filter and update:")
+	{
+		// begin combined
+		f := filter.Merge(
+			filter.Eq("description", "Matcha Latte"),
+			filter.Where("styles", filter.All("hot", "iced"), filter.Size(3)),
+		)
+		u, err := update.New(
+			update.Pull("styles", "extra hot"),
+			update.Set(update.AllPositional("sizes"), 16),
+			update.CurrentDate("updated"),
+		)
+		if err != nil {
+			panic(err)
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		var updatedDoc Drink
+		err = coll.FindOneAndUpdate(context.TODO(), f, u, opts).Decode(&updatedDoc)
+		if err != nil {
+			panic(err)
+		}
+		// end combined
+		fmt.Printf("styles: %v, sizes: %v\n", updatedDoc.Styles, updatedDoc.Sizes)
+		if len(updatedDoc.Styles) != 2 || updatedDoc.Sizes[0] != 16 || updatedDoc.Sizes[2] != 16 {
+			log.Fatalf("the drink is %+v, want styles [iced hot] and every size 16", updatedDoc)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"includes/fundamentals/code-snippets/byexample"
+)
+
+// Tea is the model from the Specify a Query guide.
+type Tea struct {
+	Type   string
+	Rating int32
+	Vendor []string `bson:"vendor,omitempty" json:"vendor,omitempty"`
+}
+
+// begin search struct
+// TeaSearch describes a search form. Each field that the user fills in
+// adds a condition to the filter.
+type TeaSearch struct {
+	Type      string   `bson:"type" query:"regex"`
+	MinRating int32    `bson:"rating" query:"gte"`
+	MaxRating int32    `bson:"rating" query:"lte"`
+	Vendors   []string `bson:"vendor" query:"in"`
+	Page      int      `query:"-"`
+}
+
+// end search struct
+
+func main() {
+	var uri string
+	if uri = os.Getenv("MONGODB_URI"); uri == "" {
+		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/")
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err = client.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+
+	coll := client.Database("tea").Collection("ratings")
+	coll.Drop(context.TODO())
+	docs := []interface{}{
+		Tea{Type: "Masala", Rating: 10, Vendor: []string{"A", "C"}},
+		Tea{Type: "English Breakfast", Rating: 6},
+		Tea{Type: "Oolong", Rating: 7, Vendor: []string{"C"}},
+		Tea{Type: "Assam", Rating: 5},
+		Tea{Type: "Earl Grey", Rating: 8, Vendor: []string{"A", "B"}},
+	}
+	if _, err := coll.InsertMany(context.TODO(), docs); err != nil {
+		panic(err)
+	}
+
+	find := func(example interface{}) []Tea {
+		// begin find by example
+		filter, err := byexample.Filter(example)
+		if err != nil {
+			panic(err)
+		}
+		cursor, err := coll.Find(context.TODO(), filter, options.Find().SetSort(bson.D{{"rating", 1}}))
+		if err != nil {
+			panic(err)
+		}
+		var results []Tea
+		if err = cursor.All(context.TODO(), &results); err != nil {
+			panic(err)
+		}
+		// end find by example
+		return results
+	}
+	names := func(teas []Tea) []string {
+		var types []string
+		for _, tea := range teas {
+			types = append(types, tea.Type)
+		}
+		return types
+	}
+
+	checks := []struct {
+		example interface{}
+		want    []string
+	}{
+		{Tea{Type: "Oolong"}, []string{"Oolong"}},
+		{Tea{Rating: 6}, []string{"English Breakfast"}},
+		{TeaSearch{MinRating: 7}, []string{"Oolong", "Earl Grey", "Masala"}},
+		{TeaSearch{MinRating: 6, MaxRating: 8, Vendors: []string{"B", "C"}}, []string{"Oolong", "Earl Grey"}},
+		{TeaSearch{Type: "^E", MaxRating: 7}, []string{"English Breakfast"}},
+	}
+	for _, c := range checks {
+		got := names(find(c.example))
+		fmt.Printf("%+v: %v\n", c.example, got)
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			log.Fatalf("%+v matched %v, want %v", c.example, got, c.want)
+		}
+	}
+}
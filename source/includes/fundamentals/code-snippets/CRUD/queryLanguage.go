@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"includes/fundamentals/code-snippets/querylang"
+)
+
+type Course struct {
+	Title      string
+	Enrollment int32
+}
+
+// begin schema
+// courseSchema lists the only fields that users can filter on
+var courseSchema = querylang.Schema{
+	"title":      querylang.String,
+	"enrollment": querylang.Number,
+}
+
+// end schema
+
+// begin handler
+// listCourses returns the courses that match the q query parameter, such
+// as /courses?q=enrollment+>=+20.
+func listCourses(coll *mongo.Collection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter := bson.D{}
+		if q := r.URL.Query().Get("q"); q != "" {
+			var err error
+			if filter, err = courseSchema.Compile(q); err != nil {
+				// The error describes the problem in the user's input, so
+				// it's safe to return
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		opts := options.Find().SetSort(bson.D{{"title", 1}}).SetLimit(100)
+		cursor, err := coll.Find(r.Context(), filter, opts)
+		if err != nil {
+			http.Error(w, "the query failed", http.StatusInternalServerError)
+			return
+		}
+		var courses []Course
+		if err := cursor.All(r.Context(), &courses); err != nil {
+			http.Error(w, "the query failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(courses)
+	}
+}
+
+// end handler
+
+func sameBSON(a, b bson.D) bool {
+	rawA, err := bson.Marshal(a)
+	if err != nil {
+		panic(err)
+	}
+	rawB, err := bson.Marshal(b)
+	if err != nil {
+		panic(err)
+	}
+	return bytes.Equal(rawA, rawB)
+}
+
+// expr is a random valid query, its expected filter, and a function that
+// evaluates it in Go.
+type expr struct {
+	text   string
+	filter bson.D
+	match  func(Course) bool
+}
+
+var (
+	titles = []string{"World Fiction", "Modern Poetry", "Ancient Greece", "Physiology I", "Nonexistent"}
+	parts  = []string{"Modern", "o", "I", "an", "Fic", "zz"}
+)
+
+// genExpr returns a random query. Compound operands are always in
+// parentheses, so the expected filter never depends on precedence.
+func genExpr(random *rand.Rand, depth int) expr {
+	if depth == 0 || random.Intn(3) == 0 {
+		return genComparison(random)
+	}
+	switch random.Intn(3) {
+	case 0:
+		inner := genExpr(random, depth-1)
+		return expr{
+			"NOT (" + inner.text + ")",
+			bson.D{{"$nor", bson.A{inner.filter}}},
+			func(c Course) bool { return !inner.match(c) },
+		}
+	default:
+		keyword, operator := "AND", "$and"
+		if random.Intn(2) == 0 {
+			keyword, operator = "OR", "$or"
+		}
+		operands := make([]expr, 2+random.Intn(2))
+		texts := make([]string, len(operands))
+		list := bson.A{}
+		for i := range operands {
+			operands[i] = genExpr(random, depth-1)
+			texts[i] = "(" + operands[i].text + ")"
+			list = append(list, operands[i].filter)
+		}
+		return expr{
+			strings.Join(texts, " "+keyword+" "),
+			bson.D{{operator, list}},
+			func(c Course) bool {
+				// AND stops at the first false operand, and OR stops at the
+				// first true operand
+				or := keyword == "OR"
+				for _, o := range operands {
+					if o.match(c) == or {
+						return or
+					}
+				}
+				return !or
+			},
+		}
+	}
+}
+
+func genComparison(random *rand.Rand) expr {
+	if random.Intn(2) == 0 {
+		n := int64([]int{12, 15, 20, 35, 60, 100}[random.Intn(6)])
+		switch random.Intn(6) {
+		case 0:
+			return expr{fmt.Sprintf("enrollment = %d", n), bson.D{{"enrollment", n}},
+				func(c Course) bool { return int64(c.Enrollment) == n }}
+		case 1:
+			return expr{fmt.Sprintf("enrollment != %d", n), bson.D{{"enrollment", bson.D{{"$ne", n}}}},
+				func(c Course) bool { return int64(c.Enrollment) != n }}
+		case 2:
+			return expr{fmt.Sprintf("enrollment < %d", n), bson.D{{"enrollment", bson.D{{"$lt", n}}}},
+				func(c Course) bool { return int64(c.Enrollment) < n }}
+		case 3:
+			return expr{fmt.Sprintf("enrollment <= %d", n), bson.D{{"enrollment", bson.D{{"$lte", n}}}},
+				func(c Course) bool { return int64(c.Enrollment) <= n }}
+		case 4:
+			return expr{fmt.Sprintf("enrollment > %d", n), bson.D{{"enrollment", bson.D{{"$gt", n}}}},
+				func(c Course) bool { return int64(c.Enrollment) > n }}
+		default:
+			m := n + 1 + int64(random.Intn(40))
+			return expr{fmt.Sprintf("enrollment IN (%d, %d)", n, m), bson.D{{"enrollment", bson.D{{"$in", bson.A{n, m}}}}},
+				func(c Course) bool { return int64(c.Enrollment) == n || int64(c.Enrollment) == m }}
+		}
+	}
+
+	title := titles[random.Intn(len(titles))]
+	switch random.Intn(4) {
+	case 0:
+		return expr{"title = " + strconv.Quote(title), bson.D{{"title", title}},
+			func(c Course) bool { return c.Title == title }}
+	case 1:
+		return expr{"title != " + strconv.Quote(title), bson.D{{"title", bson.D{{"$ne", title}}}},
+			func(c Course) bool { return c.Title != title }}
+	case 2:
+		return expr{"title >= " + strconv.Quote(title), bson.D{{"title", bson.D{{"$gte", title}}}},
+			func(c Course) bool { return c.Title >= title }}
+	default:
+		part := parts[random.Intn(len(parts))]
+		return expr{"title ~ " + strconv.Quote(part), bson.D{{"title", bson.D{{"$regex", part}}}},
+			func(c Course) bool { return strings.Contains(c.Title, part) }}
+	}
+}
+
+func main() {
+	seed := flag.Int64("seed", 1, "the seed of the random queries")
+	serverQueries := flag.Int("server-queries", 200, "the number of random queries to run against the courses collection")
+	flag.Parse()
+
+	random := rand.New(rand.NewSource(*seed))
+
+	// Every generated query compiles to its expected filter
+	var generated []expr
+	for i := 0; i < *serverQueries; i++ {
+		e := genExpr(random, 3)
+		filter, err := courseSchema.Compile(e.text)
+		if err != nil {
+			log.Fatalf("%s: %v", e.text, err)
+		}
+		if !sameBSON(filter, e.filter) {
+			log.Fatalf("%s: compiled to %v, want %v", e.text, filter, e.filter)
+		}
+		generated = append(generated, e)
+	}
+	fmt.Printf("Generated %d valid queries that compile to their expected filters\n", len(generated))
+
+	var uri string
+	if uri = os.Getenv("MONGODB_URI"); uri == "" {
+		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/")
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err = client.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+
+	// The courses from the Sort and Limit guides, and one more course that
+	// the example query matches
+	coll := client.Database("db").Collection("courses")
+	coll.Drop(context.TODO())
+	courses := []Course{
+		{Title: "World Fiction", Enrollment: 35},
+		{Title: "Abstract Algebra", Enrollment: 60},
+		{Title: "Modern Poetry", Enrollment: 12},
+		{Title: "Plate Tectonics", Enrollment: 35},
+		{Title: "Romantic Era Music", Enrollment: 15},
+		{Title: "Concepts in Topology", Enrollment: 35},
+		{Title: "Ancient Greece", Enrollment: 100},
+		{Title: "Physiology I", Enrollment: 60},
+		{Title: "Modern Drama", Enrollment: 28},
+	}
+	var docs []interface{}
+	for _, course := range courses {
+		docs = append(docs, course)
+	}
+	if _, err := coll.InsertMany(context.TODO(), docs); err != nil {
+		panic(err)
+	}
+
+	// The server returns the same courses as the Go evaluation of each
+	// generated query
+	for _, e := range generated {
+		cursor, err := coll.Find(context.TODO(), e.filter)
+		if err != nil {
+			log.Fatalf("%s: %v", e.text, err)
+		}
+		var found []Course
+		if err := cursor.All(context.TODO(), &found); err != nil {
+			panic(err)
+		}
+		var got, want []string
+		for _, course := range found {
+			got = append(got, course.Title)
+		}
+		for _, course := range courses {
+			if e.match(course) {
+				want = append(want, course.Title)
+			}
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			log.Fatalf("%s: the server returned %v, want %v", e.text, got, want)
+		}
+	}
+	fmt.Printf("The server returned the expected courses for %d queries\n", len(generated))
+
+	server := httptest.NewServer(listCourses(coll))
+	defer server.Close()
+	for _, q := range []string{
+		`enrollment >= 20 AND title ~ "Modern"`,
+		`enrollment IN (12, 15) OR title = "Ancient Greece"`,
+		`$where = "sleep(1000)"`,
+		`password = "hunter2"`,
+	} {
+		resp, err := http.Get(server.URL + "/courses?q=" + url.QueryEscape(q))
+		if err != nil {
+			panic(err)
+		}
+		var body bytes.Buffer
+		body.ReadFrom(resp.Body)
+		resp.Body.Close()
+		fmt.Printf("\n%s\n%d %s", q, resp.StatusCode, body.String())
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// begin handler
+// ChangeEvent is a change event that a Consumer delivers to a Handler.
+type ChangeEvent struct {
+	OperationType string   `bson:"operationType"`
+	DocumentKey   bson.Raw `bson:"documentKey"`
+	FullDocument  bson.Raw `bson:"fullDocument"`
+	// Raw contains the complete change event document
+	Raw bson.Raw `bson:"-"`
+}
+
+// Handler processes the events in one batch of a change stream. A Consumer
+// saves the resume token of a batch only after HandleEvents returns nil, so
+// if HandleEvents returns an error or the process stops, the Consumer
+// delivers the same events again when it restarts.
+type Handler interface {
+	HandleEvents(ctx context.Context, events []ChangeEvent) error
+}
+
+// end handler
+
+// begin token store
+// tokenDocument is the document in which a Consumer persists its position in
+// the change stream.
+type tokenDocument struct {
+	Name  string   `bson:"_id"`
+	Token bson.Raw `bson:"token"`
+	// Invalidated is true if Token is the resume token of an invalidate
+	// event. Such a token can only be passed to SetStartAfter.
+	Invalidated bool      `bson:"invalidated"`
+	UpdatedAt   time.Time `bson:"updatedAt"`
+}
+
+func loadToken(ctx context.Context, tokens *mongo.Collection, name string) (*tokenDocument, error) {
+	var doc tokenDocument
+	err := tokens.FindOne(ctx, bson.D{{"_id", name}}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func saveToken(ctx context.Context, tokens *mongo.Collection, name string, token bson.Raw, invalidated bool) error {
+	update := bson.D{{"$set", bson.D{
+		{"token", token},
+		{"invalidated", invalidated},
+		{"updatedAt", time.Now()},
+	}}}
+	opts := options.Update().SetUpsert(true)
+	_, err := tokens.UpdateOne(ctx, bson.D{{"_id", name}}, update, opts)
+	return err
+}
+
+// end token store
+
+// Consumer delivers the change events of a collection to a Handler at least
+// once, and persists its position so that it continues where it stopped
+// when it restarts.
+type Consumer struct {
+	// Name identifies the consumer's resume token in the tokens collection
+	Name     string
+	Coll     *mongo.Collection
+	Tokens   *mongo.Collection
+	Pipeline mongo.Pipeline
+	Handler  Handler
+}
+
+// Checkpoint saves the current position of the change stream, if the
+// consumer has no saved position yet. A consumer that runs after Checkpoint
+// returns receives every change made after Checkpoint, even changes made
+// before Run starts.
+func (c *Consumer) Checkpoint(ctx context.Context) error {
+	saved, err := loadToken(ctx, c.Tokens, c.Name)
+	if err != nil || saved != nil {
+		return err
+	}
+	cs, err := c.Coll.Watch(ctx, c.Pipeline)
+	if err != nil {
+		return err
+	}
+	defer cs.Close(ctx)
+	return saveToken(ctx, c.Tokens, c.Name, cs.ResumeToken(), false)
+}
+
+// Run consumes change events until ctx is canceled or an error occurs.
+func (c *Consumer) Run(ctx context.Context) error {
+	for {
+		invalidated, err := c.consume(ctx)
+		if err != nil {
+			return err
+		}
+		if !invalidated {
+			return nil
+		}
+		// The collection was dropped or renamed. Open a new change stream
+		// that starts after the invalidate event.
+	}
+}
+
+// begin open stream
+func (c *Consumer) open(ctx context.Context) (*mongo.ChangeStream, error) {
+	saved, err := loadToken(ctx, c.Tokens, c.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.ChangeStream()
+	switch {
+	case saved == nil:
+		// Without a saved token, the change stream starts from now
+	case saved.Invalidated:
+		// ResumeAfter can't resume after an invalidate event
+		opts.SetStartAfter(saved.Token)
+	default:
+		opts.SetResumeAfter(saved.Token)
+	}
+	return c.Coll.Watch(ctx, c.Pipeline, opts)
+}
+
+// end open stream
+
+// begin consume
+// consume reads batches of events from the change stream, passes each
+// batch to the handler, and then saves the resume token of the batch. It
+// returns true if the change stream was invalidated.
+func (c *Consumer) consume(ctx context.Context) (bool, error) {
+	cs, err := c.open(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer cs.Close(context.Background())
+
+	for cs.Next(ctx) {
+		// Collect the events that are available without waiting. TryNext
+		// returns false when the server has no more events.
+		events := []ChangeEvent{decodeEvent(cs)}
+		for events[len(events)-1].OperationType != "invalidate" && cs.TryNext(ctx) {
+			events = append(events, decodeEvent(cs))
+		}
+		if err := cs.Err(); err != nil {
+			break
+		}
+
+		// An invalidate event is always the last event of a change stream
+		invalidated := events[len(events)-1].OperationType == "invalidate"
+		if err := c.Handler.HandleEvents(ctx, events); err != nil {
+			return false, err
+		}
+
+		// After the last available event, ResumeToken returns the token of
+		// that event or a later postBatchResumeToken from the server
+		if err := saveToken(ctx, c.Tokens, c.Name, cs.ResumeToken(), invalidated); err != nil {
+			return false, err
+		}
+		if invalidated {
+			return true, nil
+		}
+	}
+
+	if err := cs.Err(); err != nil && !errors.Is(err, context.Canceled) {
+		return false, err
+	}
+	return false, nil
+}
+
+// end consume
+
+func decodeEvent(cs *mongo.ChangeStream) ChangeEvent {
+	var event ChangeEvent
+	// Decoding a change event into this struct can't fail, because every
+	// field is optional
+	cs.Decode(&event)
+	// Copy the event, because the change stream reuses cs.Current
+	event.Raw = append(bson.Raw(nil), cs.Current...)
+	return event
+}
+
+// recorder is a Handler that records the _id of each inserted document and
+// can simulate a crash after a number of events.
+type recorder struct {
+	mu          sync.Mutex
+	seen        map[int32]int
+	events      int
+	invalidates int
+	crashAfter  int
+}
+
+var errCrash = errors.New("simulated crash")
+
+func (r *recorder) HandleEvents(ctx context.Context, events []ChangeEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, event := range events {
+		if r.crashAfter > 0 && r.events == r.crashAfter {
+			return errCrash
+		}
+		r.events++
+		switch event.OperationType {
+		case "insert":
+			r.seen[event.DocumentKey.Lookup("_id").Int32()]++
+		case "invalidate":
+			r.invalidates++
+		}
+	}
+	return nil
+}
+
+func (r *recorder) waitFor(ids []int32) {
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		missing := 0
+		for _, id := range ids {
+			if r.seen[id] == 0 {
+				missing++
+			}
+		}
+		r.mu.Unlock()
+		if missing == 0 {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Fatalf("timed out waiting for insert events")
+}
+
+func insertDocs(coll *mongo.Collection, from, to int32) []int32 {
+	var ids []int32
+	var docs []interface{}
+	for id := from; id < to; id++ {
+		ids = append(ids, id)
+		docs = append(docs, bson.D{{"_id", id}, {"title", fmt.Sprintf("Course %d", id)}})
+	}
+	if _, err := coll.InsertMany(context.TODO(), docs); err != nil {
+		panic(err)
+	}
+	return ids
+}
+
+func main() {
+	// Change streams require a replica set or sharded cluster
+	var uri string
+	if uri = os.Getenv("MONGODB_URI"); uri == "" {
+		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/")
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err = client.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+
+	db := client.Database("db")
+	coll := db.Collection("courses")
+	tokens := db.Collection("changeStreamTokens")
+	coll.Drop(context.TODO())
+	tokens.Drop(context.TODO())
+
+	// begin checkpoint
+	handler := &recorder{seen: make(map[int32]int), crashAfter: 25}
+	consumer := &Consumer{
+		Name:     "courses-consumer",
+		Coll:     coll,
+		Tokens:   tokens,
+		Pipeline: mongo.Pipeline{},
+		Handler:  handler,
+	}
+	if err := consumer.Checkpoint(context.TODO()); err != nil {
+		panic(err)
+	}
+	// end checkpoint
+
+	fmt.Println("Crash while handling events:")
+	{
+		// The consumer hasn't started, but it receives these events because
+		// it saved its position
+		insertDocs(coll, 0, 50)
+
+		err := consumer.Run(context.TODO())
+		if err != errCrash {
+			log.Fatalf("expected the consumer to crash, got %v", err)
+		}
+		fmt.Printf("Consumer stopped after handling %d events: %v\n", handler.events, err)
+	}
+
+	fmt.Println("\nRestart after the crash:")
+	{
+		// Restart the consumer, which resumes after the last saved batch
+		handler.crashAfter = 0
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- consumer.Run(ctx) }()
+
+		handler.waitFor(insertDocs(coll, 50, 60))
+
+		// Stop the consumer as if the process were killed
+		cancel()
+		if err := <-done; err != nil {
+			panic(err)
+		}
+
+		duplicates := 0
+		for id := int32(0); id < 60; id++ {
+			switch handler.seen[id] {
+			case 0:
+				log.Fatalf("the consumer never received the insert event for _id %d", id)
+			case 1:
+			default:
+				duplicates++
+			}
+		}
+		fmt.Printf("Received all 60 insert events, %d of them more than once\n", duplicates)
+	}
+
+	fmt.Println("\nRestart after the collection is dropped:")
+	{
+		// Make changes while the consumer isn't running, including dropping
+		// the collection, which invalidates the change stream
+		missed := insertDocs(coll, 60, 70)
+		if err := coll.Drop(context.TODO()); err != nil {
+			panic(err)
+		}
+		recreated := insertDocs(coll, 100, 105)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- consumer.Run(ctx) }()
+
+		handler.waitFor(append(missed, recreated...))
+		cancel()
+		if err := <-done; err != nil {
+			panic(err)
+		}
+
+		if handler.invalidates != 1 {
+			log.Fatalf("expected 1 invalidate event, got %d", handler.invalidates)
+		}
+		fmt.Println("Received the changes made before and after the collection was dropped")
+	}
+}
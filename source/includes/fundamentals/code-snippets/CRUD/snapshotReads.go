@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// snapshotTooOldCode is the server error code for a read at a cluster time
+// whose history the server no longer keeps.
+const snapshotTooOldCode = 239
+
+type Customer struct {
+	ID   int32  `bson:"_id"`
+	Name string `bson:"name"`
+}
+
+type Order struct {
+	ID         int32   `bson:"_id"`
+	CustomerID int32   `bson:"customerId"`
+	Total      float64 `bson:"total"`
+}
+
+// Report summarizes the orders and customers at one point in time.
+type Report struct {
+	AtClusterTime primitive.Timestamp
+	Customers     int
+	Orders        int
+	Revenue       float64
+}
+
+// clusterTimes records the atClusterTime value of each snapshot read that
+// the client sends or receives.
+type clusterTimes struct {
+	mu    sync.Mutex
+	times []primitive.Timestamp
+}
+
+func (c *clusterTimes) monitor() *event.CommandMonitor {
+	record := func(doc bson.Raw, path ...string) {
+		if t, i, ok := doc.Lookup(path...).TimestampOK(); ok {
+			c.mu.Lock()
+			c.times = append(c.times, primitive.Timestamp{T: t, I: i})
+			c.mu.Unlock()
+		}
+	}
+	return &event.CommandMonitor{
+		// The first read of a snapshot session sends no atClusterTime and
+		// receives the one that the server chose. Later reads send it.
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			record(e.Command, "readConcern", "atClusterTime")
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			record(e.Reply, "cursor", "atClusterTime")
+		},
+	}
+}
+
+func (c *clusterTimes) take() []primitive.Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	times := c.times
+	c.times = nil
+	return times
+}
+
+// begin report
+// buildReport reads the customers and orders collections in a snapshot
+// session. Every read in the session sees the data at the same cluster
+// time, so the report never counts an order whose customer it can't see.
+func buildReport(ctx context.Context, client *mongo.Client, pause time.Duration) (Report, error) {
+	db := client.Database("shop")
+	sessOpts := options.Session().SetSnapshot(true)
+	session, err := client.StartSession(sessOpts)
+	if err != nil {
+		return Report{}, err
+	}
+	defer session.EndSession(ctx)
+
+	var report Report
+	err = mongo.WithSession(ctx, session, func(sctx mongo.SessionContext) error {
+		cursor, err := db.Collection("customers").Find(sctx, bson.D{})
+		if err != nil {
+			return err
+		}
+		var customers []Customer
+		if err := cursor.All(sctx, &customers); err != nil {
+			return err
+		}
+
+		// Simulate a slow report
+		time.Sleep(pause)
+
+		cursor, err = db.Collection("orders").Find(sctx, bson.D{})
+		if err != nil {
+			return err
+		}
+		var orders []Order
+		if err := cursor.All(sctx, &orders); err != nil {
+			return err
+		}
+
+		known := make(map[int32]bool)
+		for _, customer := range customers {
+			known[customer.ID] = true
+		}
+		for _, order := range orders {
+			if !known[order.CustomerID] {
+				return fmt.Errorf("order %d belongs to customer %d, who isn't in the snapshot", order.ID, order.CustomerID)
+			}
+			report.Revenue += order.Total
+		}
+		report.Customers, report.Orders = len(customers), len(orders)
+		return nil
+	})
+	return report, err
+}
+
+// end report
+
+// begin retry report
+// buildReportWithRetry builds the report again in a new snapshot session if
+// the server no longer keeps the history that the session reads from. It
+// also returns the number of attempts.
+func buildReportWithRetry(ctx context.Context, client *mongo.Client, pauses ...time.Duration) (Report, int, error) {
+	var err error
+	for attempt, pause := range pauses {
+		var report Report
+		report, err = buildReport(ctx, client, pause)
+		var serverErr mongo.ServerError
+		if !errors.As(err, &serverErr) || !serverErr.HasErrorCode(snapshotTooOldCode) {
+			return report, attempt + 1, err
+		}
+		// The reads already made belong to the old snapshot, so the whole
+		// report must start again
+		log.Printf("attempt %d: %v, starting a new snapshot", attempt+1, err)
+	}
+	return Report{}, len(pauses), err
+}
+
+// end retry report
+
+// begin read at cluster time
+// countOrdersAt counts the orders at the given cluster time. Any session or
+// client can read at a cluster time, as long as the server still keeps its
+// history.
+func countOrdersAt(ctx context.Context, db *mongo.Database, at primitive.Timestamp) (int64, error) {
+	cmd := bson.D{
+		{"aggregate", "orders"},
+		{"pipeline", bson.A{bson.D{{"$count", "n"}}}},
+		{"cursor", bson.D{}},
+		{"readConcern", bson.D{{"level", "snapshot"}, {"atClusterTime", at}}},
+	}
+	var result struct {
+		Cursor struct {
+			FirstBatch []struct {
+				N int64 `bson:"n"`
+			} `bson:"firstBatch"`
+		} `bson:"cursor"`
+	}
+	if err := db.RunCommand(ctx, cmd).Decode(&result); err != nil {
+		return 0, err
+	}
+	if len(result.Cursor.FirstBatch) == 0 {
+		return 0, nil
+	}
+	return result.Cursor.FirstBatch[0].N, nil
+}
+
+// end read at cluster time
+
+// setHistoryWindow sets how many seconds of history the server keeps for
+// snapshot reads and returns the previous value.
+func setHistoryWindow(client *mongo.Client, seconds int32) (int32, error) {
+	cmd := bson.D{{"setParameter", 1}, {"minSnapshotHistoryWindowInSeconds", seconds}}
+	var result struct {
+		Was int32 `bson:"was"`
+	}
+	err := client.Database("admin").RunCommand(context.TODO(), cmd).Decode(&result)
+	return result.Was, err
+}
+
+// reportWithShortHistory builds a report with buildReportWithRetry while
+// the server keeps only one second of history, so that the first attempt
+// fails with SnapshotTooOld. It restores the previous history window before
+// it returns, so that the caller can exit on an error without leaving the
+// deployment with a short window.
+func reportWithShortHistory(client *mongo.Client) (report Report, attempts int, err error) {
+	previous, err := setHistoryWindow(client, 1)
+	if err != nil {
+		return Report{}, 0, fmt.Errorf("can't change minSnapshotHistoryWindowInSeconds: %v", err)
+	}
+	defer func() {
+		if _, restoreErr := setHistoryWindow(client, previous); restoreErr != nil && err == nil {
+			err = fmt.Errorf("can't restore minSnapshotHistoryWindowInSeconds to %d: %v", previous, restoreErr)
+		}
+	}()
+
+	// The first attempt pauses longer than the history window, and the
+	// second attempt runs quickly
+	report, attempts, err = buildReportWithRetry(context.TODO(), client, 10*time.Second, 0)
+	// A report that succeeds on the first attempt didn't test the retry
+	if err == nil && attempts < 2 {
+		err = errors.New("the slow report succeeded without a SnapshotTooOld error, so the retry didn't run")
+	}
+	return report, attempts, err
+}
+
+// writeOrders inserts a customer and an order for that customer in one
+// transaction until ctx is canceled.
+func writeOrders(ctx context.Context, client *mongo.Client, start int32, done chan<- error) {
+	db := client.Database("shop")
+	session, err := client.StartSession()
+	if err != nil {
+		done <- err
+		return
+	}
+	defer session.EndSession(context.TODO())
+
+	for id := start; ctx.Err() == nil; id++ {
+		_, err := session.WithTransaction(context.TODO(), func(sctx mongo.SessionContext) (interface{}, error) {
+			if _, err := db.Collection("customers").InsertOne(sctx, Customer{id, fmt.Sprintf("Customer %d", id)}); err != nil {
+				return nil, err
+			}
+			return db.Collection("orders").InsertOne(sctx, Order{id, id, float64(id%50) + 0.99})
+		})
+		if err != nil {
+			done <- err
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	done <- nil
+}
+
+func main() {
+	// Snapshot reads require a replica set or sharded cluster that runs
+	// MongoDB 5.0 or later
+	var uri string
+	if uri = os.Getenv("MONGODB_URI"); uri == "" {
+		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/")
+	}
+
+	var times clusterTimes
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri).SetMonitor(times.monitor()))
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err = client.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+
+	db := client.Database("shop")
+	if err := db.Drop(context.TODO()); err != nil {
+		panic(err)
+	}
+	for _, name := range []string{"customers", "orders"} {
+		if err := db.CreateCollection(context.TODO(), name); err != nil {
+			panic(err)
+		}
+	}
+
+	// Write customers and orders while the reports run
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go writeOrders(ctx, client, 1, done)
+	stopWriter := func() {
+		cancel()
+		if err := <-done; err != nil {
+			panic(err)
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	fmt.Println("Consistent report:")
+	times.take()
+	report, err := buildReport(context.TODO(), client, time.Second)
+	if err != nil {
+		stopWriter()
+		log.Fatal(err)
+	}
+	observed := times.take()
+	if len(observed) == 0 {
+		stopWriter()
+		log.Fatal("the server didn't return an atClusterTime, it must run MongoDB 5.0 or later")
+	}
+	for _, t := range observed {
+		if !t.Equal(observed[0]) {
+			stopWriter()
+			log.Fatalf("the session read at several cluster times: %v", observed)
+		}
+	}
+	report.AtClusterTime = observed[0]
+	fmt.Printf("%d customers, %d orders, revenue %.2f\n", report.Customers, report.Orders, report.Revenue)
+	fmt.Printf("All %d snapshot reads used atClusterTime %v\n", len(observed), report.AtClusterTime)
+
+	fmt.Println("\nRead again at the same cluster time:")
+	count, err := countOrdersAt(context.TODO(), db, report.AtClusterTime)
+	if err != nil {
+		stopWriter()
+		log.Fatal(err)
+	}
+	if count != int64(report.Orders) {
+		stopWriter()
+		log.Fatalf("found %d orders at %v, the report found %d", count, report.AtClusterTime, report.Orders)
+	}
+	fmt.Printf("%d orders at %v, although more orders exist now\n", count, report.AtClusterTime)
+
+	fmt.Println("\nRecover from SnapshotTooOld:")
+	report, attempts, err := reportWithShortHistory(client)
+	stopWriter()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%d customers, %d orders, revenue %.2f after %d attempts\n", report.Customers, report.Orders, report.Revenue, attempts)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	p "includes/fundamentals/code-snippets/pipeline"
+)
+
+type Tea struct {
+	Type     string
+	Category string
+	Toppings []string
+	Price    float32
+}
+
+// sameBSON reports whether a and b marshal to the same BSON bytes.
+func sameBSON(a, b interface{}) bool {
+	rawA, err := bson.Marshal(bson.D{{"v", a}})
+	if err != nil {
+		panic(err)
+	}
+	rawB, err := bson.Marshal(bson.D{{"v", b}})
+	if err != nil {
+		panic(err)
+	}
+	return bytes.Equal(rawA, rawB)
+}
+
+func main() {
+	var uri string
+	if uri = os.Getenv("MONGODB_URI"); uri == "" {
+		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/")
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err = client.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+
+	coll := client.Database("tea").Collection("menu")
+	coll.Drop(context.TODO())
+	docs := []interface{}{
+		Tea{Type: "Masala", Category: "black", Toppings: []string{"ginger", "pumpkin spice", "cinnamon"}, Price: 6.75},
+		Tea{Type: "Gyokuro", Category: "green", Toppings: []string{"berries", "milk foam"}, Price: 5.65},
+		Tea{Type: "English Breakfast", Category: "black", Toppings: []string{"whipped cream", "honey"}, Price: 5.75},
+		Tea{Type: "Sencha", Category: "green", Toppings: []string{"lemon", "whipped cream"}, Price: 5.15},
+		Tea{Type: "Assam", Category: "black", Toppings: []string{"milk foam", "honey", "berries"}, Price: 5.65},
+		Tea{Type: "Matcha", Category: "green", Toppings: []string{"whipped cream", "honey"}, Price: 6.45},
+		Tea{Type: "Earl Grey", Category: "black", Toppings: []string{"milk foam", "pumpkin spice"}, Price: 6.15},
+		Tea{Type: "Hojicha", Category: "green", Toppings: []string{"lemon", "ginger", "milk foam"}, Price: 5.55},
+	}
+	if _, err := coll.InsertMany(context.TODO(), docs); err != nil {
+		panic(err)
+	}
+
+	run := func(pipeline mongo.Pipeline, results interface{}) {
+		cursor, err := coll.Aggregate(context.TODO(), pipeline)
+		if err != nil {
+			panic(err)
+		}
+		if err = cursor.All(context.TODO(), results); err != nil {
+			panic(err)
+		}
+	}
+
+	fmt.Println("\nAverage price:")
+	{
+		// begin raw average
+		rawPipeline := mongo.Pipeline{
+			bson.D{{"$group", bson.D{
+				{"_id", "$category"},
+				{"average_price", bson.D{{"$avg", "$price"}}},
+				{"type_total", bson.D{{"$sum", 1}}},
+			}}},
+			bson.D{{"$sort", bson.D{{"_id", 1}}}},
+		}
+		// end raw average
+
+		// begin builder average
+		pipeline := mongo.Pipeline{
+			p.Group(p.Ref("category"),
+				p.Avg("average_price", p.Ref("price")),
+				p.Count("type_total"),
+			),
+			p.Sort(p.Asc("_id")),
+		}
+		// end builder average
+
+		if !sameBSON(pipeline, rawPipeline) {
+			log.Fatalf("the builder pipeline is %v, want %v", pipeline, rawPipeline)
+		}
+
+		type categoryPrice struct {
+			Category     string  `bson:"_id"`
+			AveragePrice float64 `bson:"average_price"`
+			TypeTotal    int32   `bson:"type_total"`
+		}
+		var results, rawResults []categoryPrice
+		run(pipeline, &results)
+		run(rawPipeline, &rawResults)
+		if !reflect.DeepEqual(results, rawResults) {
+			log.Fatalf("the builder pipeline returned %+v, want %+v", results, rawResults)
+		}
+		for _, result := range results {
+			fmt.Printf("Average price of %v tea options: $%.3f (%d teas)\n", result.Category, result.AveragePrice, result.TypeTotal)
+		}
+	}
+
+	fmt.Println("\nCheapest teas with milk foam:")
+	{
+		// begin raw unset
+		rawPipeline := mongo.Pipeline{
+			bson.D{{"$match", bson.D{{"toppings", "milk foam"}}}},
+			bson.D{{"$unset", bson.A{"_id", "category"}}},
+			bson.D{{"$sort", bson.D{{"price", 1}, {"toppings", 1}}}},
+			bson.D{{"$limit", 2}},
+		}
+		// end raw unset
+
+		// begin builder unset
+		pipeline := mongo.Pipeline{
+			p.Match(bson.D{{"toppings", "milk foam"}}),
+			p.Unset("_id", "category"),
+			p.Sort(p.Asc("price"), p.Asc("toppings")),
+			p.Limit(2),
+		}
+		// end builder unset
+
+		if !sameBSON(pipeline, rawPipeline) {
+			log.Fatalf("the builder pipeline is %v, want %v", pipeline, rawPipeline)
+		}
+
+		var results, rawResults []Tea
+		run(pipeline, &results)
+		run(rawPipeline, &rawResults)
+		if !reflect.DeepEqual(results, rawResults) {
+			log.Fatalf("the builder pipeline returned %+v, want %+v", results, rawResults)
+		}
+		for _, result := range results {
+			fmt.Printf("%v: $%v\n", result.Type, result.Price)
+		}
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Tea struct {
+	Type     string
+	Category string
+	Toppings []string
+	Price    float32
+}
+
+// start-order-struct
+type Order struct {
+	ID       int32    `bson:"_id"`
+	Tea      string   `bson:"tea"`
+	Quantity int32    `bson:"quantity"`
+	Toppings []string `bson:"toppings"`
+	Status   string   `bson:"status"`
+}
+
+// Category is a node in the category hierarchy. The top category has no
+// parent.
+type Category struct {
+	Name   string `bson:"_id"`
+	Parent string `bson:"parent,omitempty"`
+}
+
+// end-order-struct
+
+func main() {
+	var uri string
+	if uri = os.Getenv("MONGODB_URI"); uri == "" {
+		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/")
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err = client.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+
+	db := client.Database("tea")
+	if err := db.Drop(context.TODO()); err != nil {
+		panic(err)
+	}
+	menu := db.Collection("menu")
+	teas := []Tea{
+		{Type: "Masala", Category: "black", Toppings: []string{"ginger", "pumpkin spice", "cinnamon"}, Price: 6.75},
+		{Type: "Gyokuro", Category: "green", Toppings: []string{"berries", "milk foam"}, Price: 5.65},
+		{Type: "English Breakfast", Category: "black", Toppings: []string{"whipped cream", "honey"}, Price: 5.75},
+		{Type: "Sencha", Category: "green", Toppings: []string{"lemon", "whipped cream"}, Price: 5.15},
+		{Type: "Assam", Category: "black", Toppings: []string{"milk foam", "honey", "berries"}, Price: 5.65},
+		{Type: "Matcha", Category: "green", Toppings: []string{"whipped cream", "honey"}, Price: 6.45},
+		{Type: "Earl Grey", Category: "black", Toppings: []string{"milk foam", "pumpkin spice"}, Price: 6.15},
+		{Type: "Hojicha", Category: "green", Toppings: []string{"lemon", "ginger", "milk foam"}, Price: 5.55},
+	}
+	var docs []interface{}
+	for _, tea := range teas {
+		docs = append(docs, tea)
+	}
+	if _, err := menu.InsertMany(context.TODO(), docs); err != nil {
+		panic(err)
+	}
+
+	// begin insert orders
+	orders := []Order{
+		{ID: 1, Tea: "Masala", Quantity: 2, Toppings: []string{"ginger"}, Status: "paid"},
+		{ID: 2, Tea: "Matcha", Quantity: 1, Toppings: []string{"honey"}, Status: "paid"},
+		{ID: 3, Tea: "Masala", Quantity: 1, Toppings: []string{}, Status: "paid"},
+		{ID: 4, Tea: "Sencha", Quantity: 3, Toppings: []string{"lemon"}, Status: "pending"},
+		{ID: 5, Tea: "Earl Grey", Quantity: 2, Toppings: []string{"milk foam", "pumpkin spice"}, Status: "paid"},
+		{ID: 6, Tea: "Gyokuro", Quantity: 1, Toppings: []string{"milk foam"}, Status: "paid"},
+		{ID: 7, Tea: "Matcha", Quantity: 2, Toppings: []string{"whipped cream", "honey"}, Status: "paid"},
+	}
+	docs = nil
+	for _, order := range orders {
+		docs = append(docs, order)
+	}
+	if _, err := db.Collection("orders").InsertMany(context.TODO(), docs); err != nil {
+		panic(err)
+	}
+
+	categories := []interface{}{
+		Category{Name: "tea"},
+		Category{Name: "true tea", Parent: "tea"},
+		Category{Name: "black", Parent: "true tea"},
+		Category{Name: "green", Parent: "true tea"},
+	}
+	if _, err := db.Collection("categories").InsertMany(context.TODO(), categories); err != nil {
+		panic(err)
+	}
+	// end insert orders
+
+	// Values that the pipelines must return, calculated from the inserted
+	// documents
+	prices := make(map[string]float64)
+	for _, tea := range teas {
+		prices[tea.Type] = float64(tea.Price)
+	}
+	paidCups := make(map[string]int32)
+	toppingCups := make(map[string]int32)
+	for _, order := range orders {
+		if order.Status != "paid" {
+			continue
+		}
+		paidCups[order.Tea] += order.Quantity
+		for _, topping := range order.Toppings {
+			toppingCups[topping] += order.Quantity
+		}
+	}
+
+	fmt.Println("$lookup with a pipeline:")
+	{
+		// begin lookup
+		// Join each tea with its paid orders. The let variable passes the
+		// tea type to the pipeline, which runs on the orders collection.
+		lookupStage := bson.D{{"$lookup", bson.D{
+			{"from", "orders"},
+			{"let", bson.D{{"teaType", "$type"}}},
+			{"pipeline", bson.A{
+				bson.D{{"$match", bson.D{{"$expr", bson.D{{"$and", bson.A{
+					bson.D{{"$eq", bson.A{"$tea", "$$teaType"}}},
+					bson.D{{"$eq", bson.A{"$status", "paid"}}},
+				}}}}}}},
+				bson.D{{"$project", bson.D{{"_id", 0}, {"quantity", 1}}}},
+			}},
+			{"as", "orders"},
+		}}}
+		projectStage := bson.D{{"$project", bson.D{
+			{"_id", 0},
+			{"type", 1},
+			{"cups", bson.D{{"$sum", "$orders.quantity"}}},
+			{"revenue", bson.D{{"$multiply", bson.A{"$price", bson.D{{"$sum", "$orders.quantity"}}}}}},
+		}}}
+		sortStage := bson.D{{"$sort", bson.D{{"revenue", -1}, {"type", 1}}}}
+
+		cursor, err := menu.Aggregate(context.TODO(), mongo.Pipeline{lookupStage, projectStage, sortStage})
+		if err != nil {
+			panic(err)
+		}
+
+		var results []struct {
+			Type    string  `bson:"type"`
+			Cups    int32   `bson:"cups"`
+			Revenue float64 `bson:"revenue"`
+		}
+		if err = cursor.All(context.TODO(), &results); err != nil {
+			panic(err)
+		}
+		for _, result := range results {
+			fmt.Printf("%s: %d cups, $%.2f\n", result.Type, result.Cups, result.Revenue)
+		}
+		// end lookup
+
+		if len(results) != len(teas) {
+			log.Fatalf("$lookup returned %d teas, want %d", len(results), len(teas))
+		}
+		for i, result := range results {
+			want := prices[result.Type] * float64(paidCups[result.Type])
+			if result.Cups != paidCups[result.Type] || math.Abs(result.Revenue-want) > 1e-9 {
+				log.Fatalf("%s: %d cups and $%v, want %d cups and $%v", result.Type, result.Cups, result.Revenue, paidCups[result.Type], want)
+			}
+			if i > 0 && result.Revenue > results[i-1].Revenue {
+				log.Fatalf("%s is sorted after %s, which has less revenue", result.Type, results[i-1].Type)
+			}
+		}
+	}
+
+	fmt.Println("\n$unwind:")
+	{
+		// begin unwind
+		// $unwind outputs one document for each topping of an order, so
+		// $group can count the cups ordered with each topping
+		matchStage := bson.D{{"$match", bson.D{{"status", "paid"}}}}
+		unwindStage := bson.D{{"$unwind", "$toppings"}}
+		groupStage := bson.D{{"$group", bson.D{
+			{"_id", "$toppings"},
+			{"cups", bson.D{{"$sum", "$quantity"}}},
+		}}}
+		sortStage := bson.D{{"$sort", bson.D{{"cups", -1}, {"_id", 1}}}}
+
+		cursor, err := db.Collection("orders").Aggregate(context.TODO(), mongo.Pipeline{matchStage, unwindStage, groupStage, sortStage})
+		if err != nil {
+			panic(err)
+		}
+
+		var results []struct {
+			Topping string `bson:"_id"`
+			Cups    int32  `bson:"cups"`
+		}
+		if err = cursor.All(context.TODO(), &results); err != nil {
+			panic(err)
+		}
+		for _, result := range results {
+			fmt.Printf("%s: %d cups\n", result.Topping, result.Cups)
+		}
+		// end unwind
+
+		if len(results) != len(toppingCups) {
+			log.Fatalf("$unwind returned %d toppings, want %d", len(results), len(toppingCups))
+		}
+		for _, result := range results {
+			if result.Cups != toppingCups[result.Topping] {
+				log.Fatalf("%s: %d cups, want %d", result.Topping, result.Cups, toppingCups[result.Topping])
+			}
+		}
+	}
+
+	fmt.Println("\n$facet:")
+	{
+		// begin facet
+		// $facet runs several pipelines on the same input documents and
+		// returns one document with the results of each pipeline
+		facetStage := bson.D{{"$facet", bson.D{
+			{"categories", bson.A{
+				bson.D{{"$sortByCount", "$category"}},
+			}},
+			{"prices", bson.A{
+				bson.D{{"$group", bson.D{
+					{"_id", nil},
+					{"min", bson.D{{"$min", "$price"}}},
+					{"max", bson.D{{"$max", "$price"}}},
+				}}},
+			}},
+			{"cheapest", bson.A{
+				bson.D{{"$sort", bson.D{{"price", 1}, {"type", 1}}}},
+				bson.D{{"$limit", 3}},
+				bson.D{{"$project", bson.D{{"_id", 0}, {"type", 1}, {"price", 1}}}},
+			}},
+		}}}
+
+		cursor, err := menu.Aggregate(context.TODO(), mongo.Pipeline{facetStage})
+		if err != nil {
+			panic(err)
+		}
+
+		var results []struct {
+			Categories []struct {
+				Name  string `bson:"_id"`
+				Count int32  `bson:"count"`
+			} `bson:"categories"`
+			Prices []struct {
+				Min float64 `bson:"min"`
+				Max float64 `bson:"max"`
+			} `bson:"prices"`
+			Cheapest []Tea `bson:"cheapest"`
+		}
+		if err = cursor.All(context.TODO(), &results); err != nil {
+			panic(err)
+		}
+		facets := results[0]
+		for _, category := range facets.Categories {
+			fmt.Printf("%s: %d teas\n", category.Name, category.Count)
+		}
+		fmt.Printf("Prices from $%.2f to $%.2f\n", facets.Prices[0].Min, facets.Prices[0].Max)
+		for _, tea := range facets.Cheapest {
+			fmt.Printf("%s: $%.2f\n", tea.Type, tea.Price)
+		}
+		// end facet
+
+		byPrice := append([]Tea(nil), teas...)
+		sort.Slice(byPrice, func(i, j int) bool {
+			if byPrice[i].Price != byPrice[j].Price {
+				return byPrice[i].Price < byPrice[j].Price
+			}
+			return byPrice[i].Type < byPrice[j].Type
+		})
+		if len(facets.Categories) != 2 || facets.Categories[0].Count+facets.Categories[1].Count != int32(len(teas)) {
+			log.Fatalf("$sortByCount returned %+v, want 2 categories with %d teas", facets.Categories, len(teas))
+		}
+		if facets.Prices[0].Min != float64(byPrice[0].Price) || facets.Prices[0].Max != float64(byPrice[len(byPrice)-1].Price) {
+			log.Fatalf("the price range is %+v, want %v to %v", facets.Prices[0], byPrice[0].Price, byPrice[len(byPrice)-1].Price)
+		}
+		for i, tea := range facets.Cheapest {
+			if tea.Type != byPrice[i].Type {
+				log.Fatalf("cheapest tea %d is %s, want %s", i+1, tea.Type, byPrice[i].Type)
+			}
+		}
+	}
+
+	fmt.Println("\n$bucketAuto:")
+	{
+		// begin bucket auto
+		// $bucketAuto chooses the boundaries of three price ranges so that
+		// each range contains about the same number of teas
+		bucketStage := bson.D{{"$bucketAuto", bson.D{
+			{"groupBy", "$price"},
+			{"buckets", 3},
+			{"output", bson.D{
+				{"count", bson.D{{"$sum", 1}}},
+				{"types", bson.D{{"$push", "$type"}}},
+			}},
+		}}}
+
+		cursor, err := menu.Aggregate(context.TODO(), mongo.Pipeline{bucketStage})
+		if err != nil {
+			panic(err)
+		}
+
+		var results []struct {
+			Range struct {
+				Min float64 `bson:"min"`
+				Max float64 `bson:"max"`
+			} `bson:"_id"`
+			Count int32    `bson:"count"`
+			Types []string `bson:"types"`
+		}
+		if err = cursor.All(context.TODO(), &results); err != nil {
+			panic(err)
+		}
+		for _, result := range results {
+			fmt.Printf("$%.2f to $%.2f: %s\n", result.Range.Min, result.Range.Max, strings.Join(result.Types, ", "))
+		}
+		// end bucket auto
+
+		// Every tea must be in exactly one range. The upper bound of a range
+		// is exclusive, except for the last range.
+		var total int32
+		for i, result := range results {
+			total += result.Count
+			last := i == len(results)-1
+			for _, teaType := range result.Types {
+				price := prices[teaType]
+				if price < result.Range.Min || price > result.Range.Max || (!last && price == result.Range.Max) {
+					log.Fatalf("%s costs $%v, which is outside $%v to $%v", teaType, price, result.Range.Min, result.Range.Max)
+				}
+			}
+		}
+		if len(results) != 3 || total != int32(len(teas)) {
+			log.Fatalf("$bucketAuto returned %d ranges with %d teas, want 3 ranges with %d teas", len(results), total, len(teas))
+		}
+	}
+
+	fmt.Println("\n$graphLookup:")
+	{
+		// begin graph lookup
+		// $graphLookup starts at the category of each tea and follows the
+		// parent field up the hierarchy. The depth field records how many
+		// steps each ancestor is from the tea's category.
+		graphLookupStage := bson.D{{"$graphLookup", bson.D{
+			{"from", "categories"},
+			{"startWith", "$category"},
+			{"connectFromField", "parent"},
+			{"connectToField", "_id"},
+			{"as", "ancestors"},
+			{"depthField", "depth"},
+		}}}
+		matchStage := bson.D{{"$match", bson.D{{"type", bson.D{{"$in", bson.A{"Masala", "Sencha"}}}}}}}
+		projectStage := bson.D{{"$project", bson.D{{"_id", 0}, {"type", 1}, {"ancestors", 1}}}}
+
+		cursor, err := menu.Aggregate(context.TODO(), mongo.Pipeline{matchStage, graphLookupStage, projectStage})
+		if err != nil {
+			panic(err)
+		}
+
+		var results []struct {
+			Type      string `bson:"type"`
+			Ancestors []struct {
+				Name  string `bson:"_id"`
+				Depth int64  `bson:"depth"`
+			} `bson:"ancestors"`
+		}
+		if err = cursor.All(context.TODO(), &results); err != nil {
+			panic(err)
+		}
+		for _, result := range results {
+			// $graphLookup doesn't order the documents that it finds
+			path := make([]string, len(result.Ancestors))
+			for _, ancestor := range result.Ancestors {
+				path[len(path)-1-int(ancestor.Depth)] = ancestor.Name
+			}
+			fmt.Printf("%s: %s\n", result.Type, strings.Join(path, " > "))
+		}
+		// end graph lookup
+
+		parents := make(map[string]string)
+		for _, doc := range categories {
+			category := doc.(Category)
+			parents[category.Name] = category.Parent
+		}
+		for _, result := range results {
+			var tea Tea
+			for _, t := range teas {
+				if t.Type == result.Type {
+					tea = t
+				}
+			}
+			depth := int64(0)
+			for name := tea.Category; name != ""; name = parents[name] {
+				found := false
+				for _, ancestor := range result.Ancestors {
+					found = found || (ancestor.Name == name && ancestor.Depth == depth)
+				}
+				if !found {
+					log.Fatalf("%s: the ancestors %+v don't include %s at depth %d", result.Type, result.Ancestors, name, depth)
+				}
+				depth++
+			}
+			if int64(len(result.Ancestors)) != depth {
+				log.Fatalf("%s has %d ancestors, want %d", result.Type, len(result.Ancestors), depth)
+			}
+		}
+	}
+
+	fmt.Println("\n$out and $merge:")
+	{
+		// begin out
+		// $out replaces the price_list collection with the results of the
+		// pipeline
+		sortStage := bson.D{{"$sort", bson.D{{"category", 1}, {"price", 1}}}}
+		groupStage := bson.D{{"$group", bson.D{
+			{"_id", "$category"},
+			{"teas", bson.D{{"$push", bson.D{{"type", "$type"}, {"price", "$price"}}}}},
+		}}}
+		outStage := bson.D{{"$out", "price_list"}}
+
+		cursor, err := menu.Aggregate(context.TODO(), mongo.Pipeline{sortStage, groupStage, outStage})
+		if err != nil {
+			panic(err)
+		}
+		if err = cursor.Close(context.TODO()); err != nil {
+			panic(err)
+		}
+		// end out
+
+		var priceList []struct {
+			Category string `bson:"_id"`
+			Teas     []Tea  `bson:"teas"`
+		}
+		cursor, err = db.Collection("price_list").Find(context.TODO(), bson.D{}, options.Find().SetSort(bson.D{{"_id", 1}}))
+		if err != nil {
+			panic(err)
+		}
+		if err = cursor.All(context.TODO(), &priceList); err != nil {
+			panic(err)
+		}
+		listed := 0
+		for _, entry := range priceList {
+			for _, tea := range entry.Teas {
+				if prices[tea.Type] != float64(tea.Price) {
+					log.Fatalf("price_list has $%v for %s, want $%v", tea.Price, tea.Type, prices[tea.Type])
+				}
+			}
+			listed += len(entry.Teas)
+		}
+		if listed != len(teas) {
+			log.Fatalf("price_list has %d teas, want %d", listed, len(teas))
+		}
+		fmt.Printf("price_list has %d categories\n", len(priceList))
+
+		// begin merge
+		// $merge adds the cups in a batch of orders to the running totals in
+		// the tea_sales collection, and inserts a total for a tea that
+		// has no total yet
+		salesFor := func(minID, maxID int32) mongo.Pipeline {
+			matchStage := bson.D{{"$match", bson.D{
+				{"status", "paid"},
+				{"_id", bson.D{{"$gte", minID}, {"$lte", maxID}}},
+			}}}
+			groupStage := bson.D{{"$group", bson.D{
+				{"_id", "$tea"},
+				{"cups", bson.D{{"$sum", "$quantity"}}},
+			}}}
+			mergeStage := bson.D{{"$merge", bson.D{
+				{"into", "tea_sales"},
+				{"on", "_id"},
+				{"whenMatched", bson.A{
+					bson.D{{"$set", bson.D{{"cups", bson.D{{"$add", bson.A{"$cups", "$$new.cups"}}}}}}},
+				}},
+				{"whenNotMatched", "insert"},
+			}}}
+			return mongo.Pipeline{matchStage, groupStage, mergeStage}
+		}
+
+		for _, batch := range [][2]int32{{1, 4}, {5, 7}} {
+			cursor, err := db.Collection("orders").Aggregate(context.TODO(), salesFor(batch[0], batch[1]))
+			if err != nil {
+				panic(err)
+			}
+			if err = cursor.Close(context.TODO()); err != nil {
+				panic(err)
+			}
+		}
+		// end merge
+
+		cursor, err = db.Collection("tea_sales").Find(context.TODO(), bson.D{})
+		if err != nil {
+			panic(err)
+		}
+		var sales []struct {
+			Tea  string `bson:"_id"`
+			Cups int32  `bson:"cups"`
+		}
+		if err = cursor.All(context.TODO(), &sales); err != nil {
+			panic(err)
+		}
+		if len(sales) != len(paidCups) {
+			log.Fatalf("tea_sales has %d teas, want %d", len(sales), len(paidCups))
+		}
+		for _, sale := range sales {
+			if sale.Cups != paidCups[sale.Tea] {
+				log.Fatalf("tea_sales has %d cups of %s, want %d", sale.Cups, sale.Tea, paidCups[sale.Tea])
+			}
+			fmt.Printf("%s: %d cups\n", sale.Tea, sale.Cups)
+		}
+	}
+}
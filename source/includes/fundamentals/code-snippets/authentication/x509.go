@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const port = "27117"
+
+// begin generate certificates
+type certificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newCertificate creates a certificate from the template and signs it with
+// the parent certificate. If parent is nil, the certificate is self-signed.
+func newCertificate(template *x509.Certificate, parent *certificate) (*certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(24 * time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	return &certificate{cert: cert, key: key, pem: append(certPEM, keyPEM...)}, nil
+}
+
+func newCA(name string) (*certificate, error) {
+	return newCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: name, Organization: []string{"MongoDB Docs"}},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}, nil)
+}
+
+// The server certificate is valid for the 127.0.0.1 IP address and the
+// localhost host name only.
+func newServerCertificate(ca *certificate) (*certificate, error) {
+	return newCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost", Organization: []string{"MongoDB Server"}},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+}
+
+// The client certificate subject must differ from the server certificate in
+// its O, OU, or DC attributes, otherwise the server treats the client as a
+// member of the cluster.
+func newClientCertificate(ca *certificate) (*certificate, error) {
+	return newCertificate(&x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:         "goClient",
+			OrganizationalUnit: []string{"Drivers"},
+			Organization:       []string{"MongoDB Clients"},
+			Country:            []string{"US"},
+		},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+}
+
+// end generate certificates
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run starts mongod and connects to it. It returns an error instead of
+// exiting so that its deferred calls stop mongod and remove the generated
+// files before main exits.
+func run() error {
+	dir, err := ioutil.TempDir("", "x509-example")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, err := newCA("MongoDB Docs CA")
+	if err != nil {
+		panic(err)
+	}
+	serverCert, err := newServerCertificate(ca)
+	if err != nil {
+		panic(err)
+	}
+	clientCert, err := newClientCertificate(ca)
+	if err != nil {
+		panic(err)
+	}
+	untrustedCA, err := newCA("Untrusted CA")
+	if err != nil {
+		panic(err)
+	}
+
+	caFile := filepath.Join(dir, "ca.pem")
+	serverFile := filepath.Join(dir, "server.pem")
+	clientFile := filepath.Join(dir, "client.pem")
+	files := map[string][]byte{
+		caFile:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}),
+		serverFile: serverCert.pem,
+		clientFile: clientCert.pem,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(name, content, 0600); err != nil {
+			panic(err)
+		}
+	}
+
+	// begin start mongod
+	dbPath := filepath.Join(dir, "db")
+	if err := os.Mkdir(dbPath, 0700); err != nil {
+		panic(err)
+	}
+	mongod := exec.Command("mongod",
+		"--dbpath", dbPath,
+		"--port", port,
+		"--bind_ip", "127.0.0.1",
+		"--auth",
+		"--tlsMode", "requireTLS",
+		"--tlsCertificateKeyFile", serverFile,
+		"--tlsCAFile", caFile,
+	)
+	mongod.Stdout = ioutil.Discard
+	if err := mongod.Start(); err != nil {
+		return fmt.Errorf("could not start mongod, make sure it is on your PATH: %v", err)
+	}
+	defer func() {
+		mongod.Process.Signal(os.Interrupt)
+		mongod.Wait()
+	}()
+	// end start mongod
+
+	// begin create x509 user
+	// The localhost exception allows you to create the first user, so
+	// connect over TLS without credentials and create an administrator.
+	adminURI := fmt.Sprintf("mongodb://127.0.0.1:%s/?tls=true&tlsCAFile=%s&tlsCertificateKeyFile=%s",
+		port, caFile, clientFile)
+	adminClient, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(adminURI))
+	if err != nil {
+		panic(err)
+	}
+	waitForServer(adminClient)
+
+	createAdmin := bson.D{
+		{"createUser", "admin"},
+		{"pwd", "password"},
+		{"roles", bson.A{"root"}},
+	}
+	if err := adminClient.Database("admin").RunCommand(context.TODO(), createAdmin).Err(); err != nil {
+		panic(err)
+	}
+	adminClient.Disconnect(context.TODO())
+
+	credential := options.Credential{Username: "admin", Password: "password"}
+	adminClient, err = mongo.Connect(context.TODO(), options.Client().ApplyURI(adminURI).SetAuth(credential))
+	if err != nil {
+		panic(err)
+	}
+	defer adminClient.Disconnect(context.TODO())
+
+	// The user name is the RFC 2253 subject of the client certificate, for
+	// example "CN=goClient,OU=Drivers,O=MongoDB Clients,C=US".
+	subject := clientCert.cert.Subject.String()
+	createUser := bson.D{
+		{"createUser", subject},
+		{"roles", bson.A{bson.D{{"role", "readWrite"}, {"db", "test"}}}},
+	}
+	if err := adminClient.Database("$external").RunCommand(context.TODO(), createUser).Err(); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Created user %q in the $external database\n", subject)
+	// end create x509 user
+
+	fmt.Println("\nX.509 with tlsCertificateKeyFile:")
+	{
+		// begin x509 connection string
+		uri := "mongodb://127.0.0.1:%s/?tls=true&tlsCAFile=%s&tlsCertificateKeyFile=%s"
+		uri = fmt.Sprintf(uri, port, caFile, clientFile)
+		credential := options.Credential{
+			AuthMechanism: "MONGODB-X509",
+		}
+
+		clientOpts := options.Client().ApplyURI(uri).SetAuth(credential)
+		// end x509 connection string
+
+		printConnectionStatus(clientOpts)
+	}
+
+	fmt.Println("\nX.509 with tls.Config:")
+	{
+		// begin x509 tls config
+		caPEM, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			panic(err)
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caPEM) {
+			panic("no certificates found in " + caFile)
+		}
+
+		// client.pem contains both the certificate and the private key
+		keyPair, err := tls.LoadX509KeyPair(clientFile, clientFile)
+		if err != nil {
+			panic(err)
+		}
+
+		tlsConfig := &tls.Config{
+			RootCAs:      rootCAs,
+			Certificates: []tls.Certificate{keyPair},
+		}
+		credential := options.Credential{
+			AuthMechanism: "MONGODB-X509",
+		}
+
+		clientOpts := options.Client().
+			ApplyURI("mongodb://127.0.0.1:" + port).
+			SetTLSConfig(tlsConfig).
+			SetAuth(credential)
+		// end x509 tls config
+
+		printConnectionStatus(clientOpts)
+	}
+
+	fmt.Println("\nUntrusted certificate authority:")
+	{
+		// begin untrusted ca
+		rootCAs := x509.NewCertPool()
+		rootCAs.AddCert(untrustedCA.cert)
+		keyPair, err := tls.X509KeyPair(clientCert.pem, clientCert.pem)
+		if err != nil {
+			panic(err)
+		}
+
+		clientOpts := options.Client().
+			ApplyURI("mongodb://127.0.0.1:" + port).
+			SetServerSelectionTimeout(2 * time.Second).
+			SetTLSConfig(&tls.Config{RootCAs: rootCAs, Certificates: []tls.Certificate{keyPair}}).
+			SetAuth(options.Credential{AuthMechanism: "MONGODB-X509"})
+		// end untrusted ca
+
+		if err := expectError(clientOpts, "x509: certificate signed by unknown authority"); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("\nHost name mismatch:")
+	{
+		// begin hostname mismatch
+		rootCAs := x509.NewCertPool()
+		rootCAs.AddCert(ca.cert)
+		keyPair, err := tls.X509KeyPair(clientCert.pem, clientCert.pem)
+		if err != nil {
+			panic(err)
+		}
+
+		// The server certificate is not valid for mongodb.example.com
+		tlsConfig := &tls.Config{
+			RootCAs:      rootCAs,
+			Certificates: []tls.Certificate{keyPair},
+			ServerName:   "mongodb.example.com",
+		}
+		clientOpts := options.Client().
+			ApplyURI("mongodb://127.0.0.1:" + port).
+			SetServerSelectionTimeout(2 * time.Second).
+			SetTLSConfig(tlsConfig).
+			SetAuth(options.Credential{AuthMechanism: "MONGODB-X509"})
+		// end hostname mismatch
+
+		if err := expectError(clientOpts, "x509: certificate is valid for localhost, not mongodb.example.com"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForServer pings the server until mongod accepts connections.
+func waitForServer(client *mongo.Client) {
+	var err error
+	for i := 0; i < 30; i++ {
+		if err = client.Ping(context.TODO(), nil); err == nil {
+			return
+		}
+		time.Sleep(time.Second)
+	}
+	panic(err)
+}
+
+func printConnectionStatus(clientOpts *options.ClientOptions) {
+	client, err := mongo.Connect(context.TODO(), clientOpts)
+	if err != nil {
+		panic(err)
+	}
+	defer client.Disconnect(context.TODO())
+
+	var result bson.M
+	command := bson.D{{"connectionStatus", 1}}
+	if err := client.Database("admin").RunCommand(context.TODO(), command).Decode(&result); err != nil {
+		panic(err)
+	}
+	authInfo := result["authInfo"].(bson.M)
+	fmt.Printf("Authenticated users: %v\n", authInfo["authenticatedUsers"])
+}
+
+// expectError returns an error unless the connection fails with an error
+// that contains message.
+func expectError(clientOpts *options.ClientOptions, message string) error {
+	client, err := mongo.Connect(context.TODO(), clientOpts)
+	if err != nil {
+		panic(err)
+	}
+	defer client.Disconnect(context.TODO())
+
+	err = client.Ping(context.TODO(), nil)
+	if err == nil || !strings.Contains(err.Error(), message) {
+		return fmt.Errorf("expected an error containing %q, got: %v", message, err)
+	}
+	fmt.Printf("Connection failed as expected: %s\n", message)
+	return nil
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// writeConflictCode is the server error code for a write that conflicts
+// with a write in another transaction.
+const writeConflictCode = 112
+
+var errInsufficientFunds = errors.New("insufficient funds")
+
+// Account is a document in the accounts collection. Balances are in cents.
+type Account struct {
+	ID      string `bson:"_id"`
+	Balance int64  `bson:"balance"`
+}
+
+// LedgerEntry is a document in the ledger collection. Each transfer
+// appends one entry in the same transaction that changes the balances.
+type LedgerEntry struct {
+	From   string    `bson:"from"`
+	To     string    `bson:"to"`
+	Amount int64     `bson:"amount"`
+	At     time.Time `bson:"at"`
+}
+
+// begin metrics
+// metrics counts the outcomes of transfers. Every field is updated
+// atomically, because many goroutines run transfers at the same time.
+type metrics struct {
+	committed         int64
+	insufficientFunds int64
+	attempts          int64
+	writeConflicts    int64
+	maxAttempts       int64
+}
+
+func (m *metrics) recordAttempts(n int64) {
+	atomic.AddInt64(&m.attempts, n)
+	for {
+		max := atomic.LoadInt64(&m.maxAttempts)
+		if n <= max || atomic.CompareAndSwapInt64(&m.maxAttempts, max, n) {
+			return
+		}
+	}
+}
+
+// isWriteConflict reports whether err is a write conflict. The server
+// labels write conflicts in a transaction as TransientTransactionError, so
+// WithTransaction retries them.
+func isWriteConflict(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(writeConflictCode)
+}
+
+// end metrics
+
+type bank struct {
+	client   *mongo.Client
+	accounts *mongo.Collection
+	ledger   *mongo.Collection
+	metrics  metrics
+}
+
+// begin transfer
+// transfer moves amount from one account to another and appends a ledger
+// entry. Either all three writes happen or none of them do.
+func (b *bank) transfer(ctx context.Context, from, to string, amount int64) error {
+	session, err := b.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	var attempts int64
+	txnOpts := options.Transaction().
+		SetReadConcern(readconcern.Snapshot()).
+		SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+	_, err = session.WithTransaction(ctx, func(sctx mongo.SessionContext) (interface{}, error) {
+		// WithTransaction calls this function again after a transient
+		// error, such as a write conflict
+		attempts++
+		err := b.move(sctx, from, to, amount)
+		if isWriteConflict(err) {
+			atomic.AddInt64(&b.metrics.writeConflicts, 1)
+		}
+		return nil, err
+	}, txnOpts)
+	b.metrics.recordAttempts(attempts)
+
+	switch {
+	case err == nil:
+		atomic.AddInt64(&b.metrics.committed, 1)
+	case errors.Is(err, errInsufficientFunds):
+		atomic.AddInt64(&b.metrics.insufficientFunds, 1)
+	}
+	return err
+}
+
+func (b *bank) move(sctx mongo.SessionContext, from, to string, amount int64) error {
+	// The filter on balance makes the debit fail instead of letting the
+	// balance go below zero
+	debit := bson.D{{"_id", from}, {"balance", bson.D{{"$gte", amount}}}}
+	result, err := b.accounts.UpdateOne(sctx, debit, bson.D{{"$inc", bson.D{{"balance", -amount}}}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		// WithTransaction aborts the transaction and returns this error
+		// without retrying, because it has no TransientTransactionError label
+		return errInsufficientFunds
+	}
+
+	_, err = b.accounts.UpdateOne(sctx, bson.D{{"_id", to}}, bson.D{{"$inc", bson.D{{"balance", amount}}}})
+	if err != nil {
+		return err
+	}
+
+	_, err = b.ledger.InsertOne(sctx, LedgerEntry{From: from, To: to, Amount: amount, At: time.Now()})
+	return err
+}
+
+// end transfer
+
+// begin total balance
+// totalBalance sums every balance in a snapshot transaction, so that the
+// sum reflects a single point in time even while transfers run.
+func (b *bank) totalBalance(ctx context.Context) (int64, error) {
+	session, err := b.client.StartSession()
+	if err != nil {
+		return 0, err
+	}
+	defer session.EndSession(ctx)
+
+	txnOpts := options.Transaction().SetReadConcern(readconcern.Snapshot())
+	total, err := session.WithTransaction(ctx, func(sctx mongo.SessionContext) (interface{}, error) {
+		groupStage := bson.D{{"$group", bson.D{{"_id", nil}, {"total", bson.D{{"$sum", "$balance"}}}}}}
+		cursor, err := b.accounts.Aggregate(sctx, mongo.Pipeline{groupStage})
+		if err != nil {
+			return nil, err
+		}
+		var results []struct {
+			Total int64 `bson:"total"`
+		}
+		if err := cursor.All(sctx, &results); err != nil {
+			return nil, err
+		}
+		if len(results) == 0 {
+			return int64(0), nil
+		}
+		return results[0].Total, nil
+	}, txnOpts)
+	if err != nil {
+		return 0, err
+	}
+	return total.(int64), nil
+}
+
+// end total balance
+
+// checkLedger verifies that each balance equals the opening balance plus
+// the ledger entries that credit the account minus those that debit it.
+func (b *bank) checkLedger(ctx context.Context, opening int64) error {
+	cursor, err := b.ledger.Find(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	var entries []LedgerEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return err
+	}
+	expected := make(map[string]int64)
+	for _, entry := range entries {
+		expected[entry.From] -= entry.Amount
+		expected[entry.To] += entry.Amount
+	}
+
+	cursor, err = b.accounts.Find(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	var accounts []Account
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		if want := opening + expected[account.ID]; account.Balance != want {
+			return fmt.Errorf("account %s has balance %d, but the ledger implies %d", account.ID, account.Balance, want)
+		}
+	}
+	fmt.Printf("Every balance matches the %d ledger entries\n", len(entries))
+	return nil
+}
+
+func main() {
+	numAccounts := flag.Int("accounts", 5, "the number of accounts. Fewer accounts cause more write conflicts")
+	numTransfers := flag.Int("transfers", 500, "the number of transfers to run")
+	workers := flag.Int("workers", 20, "the number of goroutines that run transfers")
+	opening := flag.Int64("opening-balance", 10000, "the opening balance of each account, in cents")
+	flag.Parse()
+	if *numAccounts < 2 {
+		log.Fatal("-accounts must be at least 2")
+	}
+
+	// Transactions require a replica set or sharded cluster
+	var uri string
+	if uri = os.Getenv("MONGODB_URI"); uri == "" {
+		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/")
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err = client.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+
+	db := client.Database("bank")
+	if err := db.Drop(context.TODO()); err != nil {
+		panic(err)
+	}
+	b := &bank{client: client, accounts: db.Collection("accounts"), ledger: db.Collection("ledger")}
+
+	// Create both collections before the transfers, because creating a
+	// collection in a transaction requires MongoDB 4.4 or later
+	var accounts []interface{}
+	ids := make([]string, *numAccounts)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("acct-%03d", i+1)
+		accounts = append(accounts, Account{ID: ids[i], Balance: *opening})
+	}
+	if _, err := b.accounts.InsertMany(context.TODO(), accounts); err != nil {
+		panic(err)
+	}
+	if err := db.CreateCollection(context.TODO(), "ledger"); err != nil {
+		panic(err)
+	}
+	want := *opening * int64(*numAccounts)
+
+	// begin run transfers
+	jobs := make(chan [2]string)
+	var wg sync.WaitGroup
+	for w := 0; w < *workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pair := range jobs {
+				amount := int64(rand.Intn(5000) + 1)
+				err := b.transfer(context.TODO(), pair[0], pair[1], amount)
+				if err != nil && !errors.Is(err, errInsufficientFunds) {
+					log.Fatalf("transfer from %s to %s failed: %v", pair[0], pair[1], err)
+				}
+			}
+		}()
+	}
+
+	// Check the invariant while the transfers run
+	done := make(chan struct{})
+	audits := make(chan int)
+	go func() {
+		count := 0
+		defer func() { audits <- count }()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			total, err := b.totalBalance(context.TODO())
+			if err != nil {
+				log.Fatalf("reading the total balance failed: %v", err)
+			}
+			if total != want {
+				log.Fatalf("the total balance is %d, want %d", total, want)
+			}
+			count++
+		}
+	}()
+
+	start := time.Now()
+	for i := 0; i < *numTransfers; i++ {
+		from := rand.Intn(len(ids))
+		to := (from + 1 + rand.Intn(len(ids)-1)) % len(ids)
+		jobs <- [2]string{ids[from], ids[to]}
+	}
+	close(jobs)
+	wg.Wait()
+	close(done)
+	// end run transfers
+
+	fmt.Printf("Ran %d transfers between %d accounts with %d goroutines in %s\n",
+		*numTransfers, *numAccounts, *workers, time.Since(start).Round(time.Millisecond))
+	fmt.Printf("The total balance was %d in all %d audits\n", want, <-audits)
+
+	total, err := b.totalBalance(context.TODO())
+	if err != nil {
+		panic(err)
+	}
+	if total != want {
+		log.Fatalf("the final total balance is %d, want %d", total, want)
+	}
+	if err := b.checkLedger(context.TODO(), *opening); err != nil {
+		log.Fatal(err)
+	}
+
+	m := &b.metrics
+	fmt.Printf("\ncommitted:            %d\n", m.committed)
+	fmt.Printf("insufficient funds:   %d\n", m.insufficientFunds)
+	fmt.Printf("write conflicts:      %d\n", m.writeConflicts)
+	fmt.Printf("transaction attempts: %d (%.2f per transfer, at most %d)\n",
+		m.attempts, float64(m.attempts)/float64(*numTransfers), m.maxAttempts)
+	if m.committed+m.insufficientFunds != int64(*numTransfers) {
+		log.Fatalf("%d transfers committed and %d failed, want %d in total", m.committed, m.insufficientFunds, *numTransfers)
+	}
+	entries, err := b.ledger.CountDocuments(context.TODO(), bson.D{})
+	if err != nil {
+		panic(err)
+	}
+	if entries != m.committed {
+		log.Fatalf("the ledger has %d entries, but %d transfers committed", entries, m.committed)
+	}
+}
@@ -0,0 +1,229 @@
+// Package byexample builds a query filter from a partially populated
+// struct, such as Tea{Type: "Oolong"}. The filter matches documents whose
+// fields equal the non-zero fields of the struct.
+//
+// Field names follow the bson struct tags in the same way as the driver:
+// a field without a name in its tag uses the lowercase field name, a field
+// tagged "-" is skipped, and the fields of an inline struct or map become
+// fields of the filter. The fields of a nested struct become dotted paths,
+// such as "address.city", so the filter matches a partial subdocument.
+// The filter never contains zero fields, so omitempty has no further
+// effect.
+//
+// A query tag replaces equality with another operator:
+//
+//	type TeaSearch struct {
+//		MinRating int32    `bson:"rating" query:"gte"`
+//		Vendors   []string `bson:"vendor" query:"in"`
+//	}
+//
+// The query tag accepts eq, ne, gt, gte, lt, lte, in, nin, all, and regex.
+// Fields tagged query:"-" never appear in the filter.
+//
+// Zero values such as 0, false, and "" can't be distinguished from unset
+// fields, so the filter omits them. To match a zero value, use a pointer
+// field. The filter includes every non-nil pointer, even one that points
+// to a zero value.
+package byexample
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var operators = map[string]string{
+	"eq":    "$eq",
+	"ne":    "$ne",
+	"gt":    "$gt",
+	"gte":   "$gte",
+	"lt":    "$lt",
+	"lte":   "$lte",
+	"in":    "$in",
+	"nin":   "$nin",
+	"all":   "$all",
+	"regex": "$regex",
+}
+
+// arrayOperators take a list of values, which the filter builds from a
+// slice or array field.
+var arrayOperators = map[string]bool{"$in": true, "$nin": true, "$all": true}
+
+const primitivePackage = "go.mongodb.org/mongo-driver/bson/primitive"
+
+var (
+	timeType           = reflect.TypeOf(time.Time{})
+	marshalerType      = reflect.TypeOf((*bson.Marshaler)(nil)).Elem()
+	valueMarshalerType = reflect.TypeOf((*bson.ValueMarshaler)(nil)).Elem()
+)
+
+var errNotStruct = errors.New("byexample: the example must be a struct or a pointer to a struct")
+
+// builder collects the conditions of the filter in field order.
+type builder struct {
+	filter bson.D
+	index  map[string]int
+}
+
+// Filter returns a filter built from the non-zero fields of example, which
+// must be a struct or a pointer to a struct.
+func Filter(example interface{}) (bson.D, error) {
+	v := reflect.ValueOf(example)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, errNotStruct
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, errNotStruct
+	}
+
+	b := &builder{filter: bson.D{}, index: make(map[string]int)}
+	if err := b.addStruct(v, ""); err != nil {
+		return nil, err
+	}
+	return b.filter, nil
+}
+
+func (b *builder) addStruct(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			// Unexported field, which the driver skips even if it's an
+			// embedded struct tagged inline
+			continue
+		}
+
+		name, inline, skip := parseBSONTag(sf)
+		query := sf.Tag.Get("query")
+		if skip || query == "-" {
+			continue
+		}
+		op, ok := operators[query]
+		if query != "" && !ok {
+			return fmt.Errorf("byexample: field %s has unsupported query tag %q", sf.Name, query)
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		} else if fv.IsZero() {
+			continue
+		}
+
+		switch {
+		case inline && fv.Kind() == reflect.Struct:
+			if err := b.addStruct(fv, prefix); err != nil {
+				return err
+			}
+		case inline && fv.Kind() == reflect.Map:
+			if err := b.addMap(fv, prefix); err != nil {
+				return err
+			}
+		case op == "" && isDocument(fv):
+			if err := b.addStruct(fv, prefix+name+"."); err != nil {
+				return err
+			}
+		default:
+			if err := b.add(prefix+name, op, fv); err != nil {
+				return fmt.Errorf("byexample: field %s: %v", sf.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// addMap adds the entries of an inline map in key order.
+func (b *builder) addMap(m reflect.Value, prefix string) error {
+	if m.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("byexample: inline map has %s keys, want string keys", m.Type().Key())
+	}
+	keys := m.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	for _, key := range keys {
+		if err := b.add(prefix+key.String(), "", m.MapIndex(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add adds the condition for one field. Several operators on the same
+// field, such as $gte and $lte, share one operator document.
+func (b *builder) add(path, op string, v reflect.Value) error {
+	value := v.Interface()
+	switch {
+	case arrayOperators[op]:
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return fmt.Errorf("%s requires a slice, not %s", op, v.Type())
+		}
+		list := make(bson.A, v.Len())
+		for i := range list {
+			list[i] = v.Index(i).Interface()
+		}
+		value = list
+	case op == "$regex" && v.Kind() != reflect.String:
+		return fmt.Errorf("$regex requires a string, not %s", v.Type())
+	}
+
+	i, exists := b.index[path]
+	if !exists {
+		b.index[path] = len(b.filter)
+		if op == "" {
+			b.filter = append(b.filter, bson.E{path, value})
+		} else {
+			b.filter = append(b.filter, bson.E{path, bson.D{{op, value}}})
+		}
+		return nil
+	}
+
+	// Equality without an operator can't share the field with another
+	// condition
+	ops, isOps := b.filter[i].Value.(bson.D)
+	if op == "" || !isOps {
+		return fmt.Errorf("more than one field sets %q", path)
+	}
+	b.filter[i].Value = append(ops, bson.E{op, value})
+	return nil
+}
+
+// parseBSONTag returns the key of a field and its inline and skip flags in
+// the same way as the driver's default struct tag parser.
+func parseBSONTag(sf reflect.StructField) (name string, inline, skip bool) {
+	tag := sf.Tag.Get("bson")
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = strings.ToLower(sf.Name)
+	}
+	for _, flag := range parts[1:] {
+		if flag == "inline" {
+			inline = true
+		}
+	}
+	return name, inline, false
+}
+
+// isDocument reports whether the driver encodes v as a subdocument whose
+// fields the filter can match one by one.
+func isDocument(v reflect.Value) bool {
+	t := v.Type()
+	if t.Kind() != reflect.Struct || t == timeType || t.PkgPath() == primitivePackage {
+		return false
+	}
+	return !t.Implements(marshalerType) && !t.Implements(valueMarshalerType) &&
+		!reflect.PtrTo(t).Implements(marshalerType) && !reflect.PtrTo(t).Implements(valueMarshalerType)
+}
@@ -0,0 +1,218 @@
+package byexample
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Tea is the model from the Specify a Query guide.
+type Tea struct {
+	Type   string
+	Rating int32
+	Vendor []string `bson:"vendor,omitempty" json:"vendor,omitempty"`
+}
+
+// BlogPost is the model from the struct tagging usage example.
+type BlogPost struct {
+	Title       string
+	Author      string
+	WordCount   int `bson:"word_count"`
+	LastUpdated time.Time
+	Tags        []string
+}
+
+// Course is the model from the Limit and Sort guides.
+type Course struct {
+	Title      string
+	Enrollment int32
+}
+
+type TeaSearch struct {
+	Type      string   `bson:"type" query:"regex"`
+	MinRating int32    `bson:"rating" query:"gte"`
+	MaxRating int32    `bson:"rating" query:"lte"`
+	Vendors   []string `bson:"vendor" query:"in"`
+	Page      int      `query:"-"`
+}
+
+type Address struct {
+	City    string `bson:"city"`
+	Country string `bson:"country"`
+}
+
+type Audit struct {
+	CreatedBy string `bson:"created_by"`
+}
+
+type audit struct {
+	CreatedBy string
+}
+
+type Vendor struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty"`
+	Name    string             `bson:"name"`
+	Address Address            `bson:"address"`
+	Audit   `bson:",inline"`
+	Extra   map[string]interface{} `bson:",inline"`
+	Active  *bool                  `bson:"active,omitempty"`
+	Notes   string                 `bson:"-"`
+}
+
+// marshal returns the BSON bytes of doc.
+func marshal(t *testing.T, doc bson.D) []byte {
+	t.Helper()
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestFilter compares the filters built from example structs to the
+// filters that the guides write as literals.
+func TestFilter(t *testing.T) {
+	id := primitive.NewObjectID()
+	active := false
+	updated := time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		example interface{}
+		want    bson.D
+	}{
+		{"Tea with a type", Tea{Type: "Oolong"},
+			bson.D{{"type", "Oolong"}}},
+		{"Tea with every field", &Tea{Type: "Masala", Rating: 10, Vendor: []string{"A", "C"}},
+			bson.D{{"type", "Masala"}, {"rating", int32(10)}, {"vendor", []string{"A", "C"}}}},
+		{"empty Tea", Tea{}, bson.D{}},
+		{"BlogPost with a renamed field", BlogPost{Author: "Sam Lee", WordCount: 682},
+			bson.D{{"author", "Sam Lee"}, {"word_count", 682}}},
+		{"BlogPost with a time", BlogPost{LastUpdated: updated},
+			bson.D{{"lastupdated", updated}}},
+		{"Course", Course{Enrollment: 32},
+			bson.D{{"enrollment", int32(32)}}},
+		{"TeaSearch", TeaSearch{Type: "^E", MinRating: 5, MaxRating: 9, Vendors: []string{"A", "B"}, Page: 2},
+			bson.D{
+				{"type", bson.D{{"$regex", "^E"}}},
+				{"rating", bson.D{{"$gte", int32(5)}, {"$lte", int32(9)}}},
+				{"vendor", bson.D{{"$in", bson.A{"A", "B"}}}},
+			}},
+		{"TeaSearch with a minimum", TeaSearch{MinRating: 7},
+			bson.D{{"rating", bson.D{{"$gte", int32(7)}}}}},
+		{"nested, inline, and pointer fields",
+			Vendor{
+				ID:      id,
+				Address: Address{City: "Lisbon"},
+				Audit:   Audit{CreatedBy: "ada"},
+				Extra:   map[string]interface{}{"tier": "gold", "region": "eu"},
+				Active:  &active,
+				Notes:   "ignored",
+			},
+			bson.D{
+				{"_id", id},
+				{"address.city", "Lisbon"},
+				{"created_by", "ada"},
+				{"region", "eu"},
+				{"tier", "gold"},
+				{"active", false},
+			}},
+		{"unexported embedded struct", struct {
+			Name string
+			audit
+		}{Name: "Lisbon", audit: audit{CreatedBy: "ada"}},
+			bson.D{{"name", "Lisbon"}}},
+		{"unexported inline struct", struct {
+			Name  string
+			audit `bson:",inline"`
+		}{Name: "Lisbon", audit: audit{CreatedBy: "ada"}},
+			bson.D{{"name", "Lisbon"}}},
+		{"unexported field", struct {
+			Name string
+			city string
+		}{Name: "Lisbon", city: "Porto"},
+			bson.D{{"name", "Lisbon"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Filter(tt.example)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(marshal(t, got), marshal(t, tt.want)) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		example interface{}
+	}{
+		{"not a struct", "Oolong"},
+		{"nil pointer", (*Tea)(nil)},
+		{"unsupported operator", struct {
+			Rating int32 `query:"between"`
+		}{Rating: 5}},
+		{"$in on a single value", struct {
+			Rating int32 `query:"in"`
+		}{Rating: 5}},
+		{"$regex on a number", struct {
+			Rating int32 `query:"regex"`
+		}{Rating: 5}},
+		{"equality and an operator on one field", struct {
+			Rating    int32
+			MinRating int32 `bson:"rating" query:"gte"`
+		}{Rating: 5, MinRating: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Filter(tt.example); err == nil {
+				t.Error("Filter() returned no error")
+			}
+		})
+	}
+}
+
+// TestFilterMatchesDriver checks that the filter of an equality-only
+// example has the same fields as the document that the driver stores for
+// it.
+func TestFilterMatchesDriver(t *testing.T) {
+	tests := []struct {
+		name    string
+		example interface{}
+	}{
+		{"Tea", Tea{Type: "Masala", Rating: 10, Vendor: []string{"A", "C"}}},
+		{"exported inline struct", struct {
+			Name  string
+			Audit `bson:",inline"`
+		}{Name: "Lisbon", Audit: Audit{CreatedBy: "ada"}}},
+		{"unexported embedded struct", struct {
+			Name string
+			audit
+		}{Name: "Lisbon", audit: audit{CreatedBy: "ada"}}},
+		{"unexported inline struct", struct {
+			Name  string
+			audit `bson:",inline"`
+		}{Name: "Lisbon", audit: audit{CreatedBy: "ada"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := Filter(tt.example)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored, err := bson.Marshal(tt.example)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(marshal(t, filter), stored) {
+				t.Errorf("got %v, want %v", filter, bson.Raw(stored))
+			}
+		})
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const troubleshootingPage = "https://www.mongodb.com/docs/drivers/go/current/connection-troubleshooting/"
+
+// Sections of the Connection Troubleshooting page that explain how to fix
+// each kind of failure.
+const (
+	sectionConnectionString = "Connection Error > Check Connection String"
+	sectionFirewall         = "Connection Error > Configure Firewall"
+	sectionAuthString       = "Authentication Error > Check Connection String"
+	sectionAuthMechanism    = "Authentication Error > Verify the Authentication Mechanism"
+	sectionAuthDatabase     = "Authentication Error > Verify User Is in Authentication Database"
+	sectionConnections      = "Error Sending Message > Check the Number of Connections"
+	sectionTimeout          = "Timeout Error > Set Timeout Option"
+)
+
+type result struct {
+	check   string
+	passed  bool
+	skipped bool
+	detail  string
+	section string
+}
+
+type doctor struct {
+	uri     string
+	opts    *options.ClientOptions
+	timeout time.Duration
+	results []result
+}
+
+func (d *doctor) pass(check, format string, args ...interface{}) {
+	d.results = append(d.results, result{check: check, passed: true, detail: fmt.Sprintf(format, args...)})
+}
+
+func (d *doctor) fail(check, section string, err error) {
+	d.results = append(d.results, result{check: check, detail: err.Error(), section: section})
+}
+
+func (d *doctor) skip(check, reason string) {
+	d.results = append(d.results, result{check: check, skipped: true, detail: reason})
+}
+
+func main() {
+	uri := flag.String("uri", os.Getenv("MONGODB_URI"), "the connection string to diagnose, defaults to $MONGODB_URI")
+	timeout := flag.Duration("timeout", 5*time.Second, "the time limit for each network check")
+	flag.Parse()
+
+	if *uri == "" {
+		log.Fatal("You must pass a connection string with -uri or set your 'MONGODB_URI' environmental variable.")
+	}
+
+	d := &doctor{uri: *uri, timeout: *timeout}
+	d.run()
+
+	failed := false
+	for _, r := range d.results {
+		status := "PASS"
+		switch {
+		case r.skipped:
+			status = "SKIP"
+		case !r.passed:
+			status = "FAIL"
+			failed = true
+		}
+		fmt.Printf("[%s] %s: %s\n", status, r.check, r.detail)
+		if r.section != "" {
+			fmt.Printf("       See %q at %s\n", r.section, troubleshootingPage)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// run performs each check in order. Each check depends on the previous one,
+// so the doctor stops at the first failure that prevents the next checks.
+func (d *doctor) run() {
+	if !d.checkURI() {
+		return
+	}
+	if !d.checkDNS() {
+		return
+	}
+	if !d.checkTCP() {
+		return
+	}
+	if !d.checkTLS() {
+		return
+	}
+	if !d.checkHello() {
+		return
+	}
+	client, ok := d.checkAuth()
+	if !ok {
+		return
+	}
+	defer client.Disconnect(context.TODO())
+	d.checkConnections(client)
+}
+
+// begin check uri
+func (d *doctor) checkURI() bool {
+	// For mongodb+srv URIs, ApplyURI also looks up the SRV and TXT records
+	d.opts = options.Client().ApplyURI(d.uri)
+	if err := d.opts.Validate(); err != nil {
+		section := sectionConnectionString
+		if strings.Contains(err.Error(), "lookup") {
+			section = sectionFirewall
+		}
+		d.fail("parse connection string", section, err)
+		return false
+	}
+	d.pass("parse connection string", "found %d host(s)", len(d.opts.Hosts))
+	return true
+}
+
+// end check uri
+
+func (d *doctor) checkDNS() bool {
+	ok := true
+	if strings.HasPrefix(d.uri, "mongodb+srv://") {
+		// The URI parsed, so the SRV lookup has already succeeded
+		d.pass("SRV lookup", "resolved to %s", strings.Join(d.opts.Hosts, ", "))
+	}
+	for _, host := range d.opts.Hosts {
+		name, _ := splitHost(host)
+		if net.ParseIP(name) != nil {
+			d.skip("DNS lookup "+name, "the host is an IP address")
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+		addrs, err := net.DefaultResolver.LookupHost(ctx, name)
+		cancel()
+		if err != nil {
+			d.fail("DNS lookup "+name, sectionConnectionString, err)
+			ok = false
+			continue
+		}
+		d.pass("DNS lookup "+name, "resolved to %s", strings.Join(addrs, ", "))
+	}
+	return ok
+}
+
+// begin check tcp
+// splitHost returns the hostname of a host from the connection string and
+// its address, which has the default port if the host has none. An IPv6
+// host such as "[::1]" is bracketed in the address but not in the name.
+func splitHost(host string) (name, addr string) {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		return name, host
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return name, net.JoinHostPort(name, "27017")
+}
+
+func (d *doctor) checkTCP() bool {
+	ok := true
+	for _, host := range d.opts.Hosts {
+		_, host = splitHost(host)
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", host, d.timeout)
+		if err != nil {
+			d.fail("TCP connect "+host, sectionFirewall, err)
+			ok = false
+			continue
+		}
+		conn.Close()
+		d.pass("TCP connect "+host, "connected in %s", time.Since(start).Round(time.Millisecond))
+	}
+	return ok
+}
+
+// end check tcp
+
+func (d *doctor) checkTLS() bool {
+	if d.opts.TLSConfig == nil {
+		d.skip("TLS handshake", "TLS is not enabled in the connection string")
+		return true
+	}
+	ok := true
+	for _, host := range d.opts.Hosts {
+		name, host := splitHost(host)
+		config := d.opts.TLSConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName = name
+		}
+		dialer := &net.Dialer{Timeout: d.timeout}
+		conn, err := tls.DialWithDialer(dialer, "tcp", host, config)
+		if err != nil {
+			d.fail("TLS handshake "+host, sectionConnectionString, err)
+			ok = false
+			continue
+		}
+		state := conn.ConnectionState()
+		conn.Close()
+		d.pass("TLS handshake "+host, "negotiated %s with %s", tlsVersion(state.Version), state.PeerCertificates[0].Subject)
+	}
+	return ok
+}
+
+// checkHello runs the hello command against each host without
+// credentials, which succeeds even if authentication is misconfigured.
+func (d *doctor) checkHello() bool {
+	ok := true
+	for _, host := range d.opts.Hosts {
+		opts := options.Client().ApplyURI(d.uri).
+			SetHosts([]string{host}).
+			SetServerSelectionTimeout(d.timeout)
+		opts.Auth = nil
+		if opts.LoadBalanced == nil || !*opts.LoadBalanced {
+			opts.SetDirect(true)
+		}
+
+		var hello struct {
+			SetName           string `bson:"setName"`
+			IsWritablePrimary bool   `bson:"isWritablePrimary"`
+			Secondary         bool   `bson:"secondary"`
+			Msg               string `bson:"msg"`
+			MaxWireVersion    int32  `bson:"maxWireVersion"`
+		}
+		err := runOnce(opts, func(client *mongo.Client) error {
+			return client.Database("admin").RunCommand(context.TODO(), bson.D{{"hello", 1}}).Decode(&hello)
+		})
+		if err != nil {
+			section := sectionConnectionString
+			if mongo.IsTimeout(err) {
+				section = sectionTimeout
+			}
+			d.fail("hello "+host, section, err)
+			ok = false
+			continue
+		}
+
+		role := "standalone"
+		switch {
+		case hello.Msg == "isdbgrid":
+			role = "mongos"
+		case hello.IsWritablePrimary && hello.SetName != "":
+			role = "primary of " + hello.SetName
+		case hello.Secondary:
+			role = "secondary of " + hello.SetName
+		}
+		d.pass("hello "+host, "%s, maxWireVersion %d", role, hello.MaxWireVersion)
+	}
+	return ok
+}
+
+// begin check auth
+func (d *doctor) checkAuth() (*mongo.Client, bool) {
+	opts := options.Client().ApplyURI(d.uri).SetServerSelectionTimeout(d.timeout)
+	client, err := mongo.Connect(context.TODO(), opts)
+	if err != nil {
+		d.fail("authenticate", sectionAuthString, err)
+		return nil, false
+	}
+
+	// The driver authenticates each connection during the handshake, so the
+	// first command returns any authentication error
+	if err := client.Ping(context.TODO(), nil); err != nil {
+		client.Disconnect(context.TODO())
+		section := sectionAuthString
+		switch {
+		case strings.Contains(err.Error(), "unable to authenticate using mechanism"):
+			section = sectionAuthDatabase
+			if d.opts.Auth != nil && d.opts.Auth.AuthMechanism != "" {
+				section = sectionAuthMechanism
+			}
+		case mongo.IsTimeout(err):
+			section = sectionTimeout
+		}
+		d.fail("authenticate", section, err)
+		return nil, false
+	}
+
+	if d.opts.Auth == nil {
+		d.pass("authenticate", "connected without credentials")
+	} else {
+		d.pass("authenticate", "authenticated as %q against %q", d.opts.Auth.Username, authSource(d.opts.Auth))
+	}
+	return client, true
+}
+
+// end check auth
+
+// begin check connections
+func (d *doctor) checkConnections(client *mongo.Client) {
+	var status struct {
+		Connections struct {
+			Current   int32 `bson:"current"`
+			Available int32 `bson:"available"`
+		} `bson:"connections"`
+	}
+	command := bson.D{{"serverStatus", 1}}
+	err := client.Database("admin").RunCommand(context.TODO(), command).Decode(&status)
+	if err != nil {
+		// Users without the clusterMonitor role can't run serverStatus
+		d.skip("connection count", err.Error())
+		return
+	}
+
+	maxPoolSize := uint64(100)
+	if d.opts.MaxPoolSize != nil {
+		maxPoolSize = *d.opts.MaxPoolSize
+	}
+	current, available := status.Connections.Current, status.Connections.Available
+	if maxPoolSize != 0 && uint64(available) < maxPoolSize {
+		err := fmt.Errorf("%d connections open, %d available, fewer than maxPoolSize (%d)", current, available, maxPoolSize)
+		d.fail("connection count", sectionConnections, err)
+		return
+	}
+	d.pass("connection count", "%d connections open, %d available", current, available)
+}
+
+// end check connections
+
+// runOnce connects a client, calls fn, and disconnects the client.
+func runOnce(opts *options.ClientOptions, fn func(*mongo.Client) error) error {
+	client, err := mongo.Connect(context.TODO(), opts)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.TODO())
+	return fn(client)
+}
+
+func authSource(cred *options.Credential) string {
+	if cred.AuthSource != "" {
+		return cred.AuthSource
+	}
+	return "admin"
+}
+
+func tlsVersion(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("TLS version %#x", version)
+}
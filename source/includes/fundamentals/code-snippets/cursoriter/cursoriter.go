@@ -0,0 +1,151 @@
+//go:build go1.23
+
+// Package cursoriter adapts cursors and change streams to range-over-func
+// iterators, so that a for loop replaces the Next(), Decode(), Err(), and
+// Close() calls:
+//
+//	for course, err := range cursoriter.All[Course](ctx, cursor) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(course.Title)
+//	}
+//
+// Each iterator closes its cursor or change stream when the loop ends,
+// including when the loop body breaks or returns early. If the cursor
+// returns an error, or a document can't be decoded into T, the iterator
+// yields the zero value of T and the error, and then stops. The iterator
+// also stops with ctx.Err() as soon as ctx is canceled, even if the
+// current batch has more documents.
+//
+// A cursor can only be read once, so each iterator can only be ranged over
+// once. Later loops yield ErrConsumed.
+package cursoriter
+
+import (
+	"context"
+	"errors"
+	"iter"
+)
+
+// ErrConsumed means that a loop ranged over an iterator that an earlier
+// loop already used.
+var ErrConsumed = errors.New("cursoriter: the iterator was already used")
+
+// Source is a cursor or a change stream. *mongo.Cursor and
+// *mongo.ChangeStream implement it.
+type Source interface {
+	Next(ctx context.Context) bool
+	Decode(val interface{}) error
+	Err() error
+	Close(ctx context.Context) error
+}
+
+// BatchSource is a Source that counts the documents left in the batch that
+// it received last, such as *mongo.Cursor. *mongo.ChangeStream doesn't have
+// a RemainingBatchLength() method in driver v1.11.
+type BatchSource interface {
+	Source
+	RemainingBatchLength() int
+}
+
+// All returns an iterator over the documents of s, decoded into T. For a
+// change stream, the loop waits for each event, so it only ends when the
+// loop body breaks, ctx is canceled, or the change stream returns an error.
+func All[T any](ctx context.Context, s Source) iter.Seq2[T, error] {
+	used := false
+	return func(yield func(T, error) bool) {
+		var zero T
+		if used {
+			yield(zero, ErrConsumed)
+			return
+		}
+		used = true
+		defer closeSource(ctx, s)
+
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(zero, err)
+				return
+			}
+			if !s.Next(ctx) {
+				if err := s.Err(); err != nil {
+					yield(zero, err)
+				}
+				return
+			}
+			var v T
+			if err := s.Decode(&v); err != nil {
+				yield(zero, err)
+				return
+			}
+			if !yield(v, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Batches returns an iterator over the batches of documents that the server
+// returns for s. Each slice holds the documents that the cursor received in
+// one reply, decoded into T, so the loop body runs once for each round
+// trip. Set the size of the batches with the SetBatchSize()
+// method of the options of the operation that creates the cursor.
+//
+// A batch only contains documents that the cursor already received, which
+// the RemainingBatchLength() method counts. The iterator only waits for the
+// server when it starts the next batch.
+func Batches[T any](ctx context.Context, s BatchSource) iter.Seq2[[]T, error] {
+	used := false
+	return func(yield func([]T, error) bool) {
+		if used {
+			yield(nil, ErrConsumed)
+			return
+		}
+		used = true
+		defer closeSource(ctx, s)
+
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(nil, err)
+				return
+			}
+			// The first document of each batch can require a round trip
+			if !s.Next(ctx) {
+				if err := s.Err(); err != nil {
+					yield(nil, err)
+				}
+				return
+			}
+			batch := make([]T, 1, 1+s.RemainingBatchLength())
+			if err := s.Decode(&batch[0]); err != nil {
+				yield(nil, err)
+				return
+			}
+			for s.RemainingBatchLength() > 0 {
+				if err := ctx.Err(); err != nil {
+					yield(nil, err)
+					return
+				}
+				if !s.Next(ctx) {
+					break
+				}
+				var v T
+				if err := s.Decode(&v); err != nil {
+					yield(nil, err)
+					return
+				}
+				batch = append(batch, v)
+			}
+			if !yield(batch, nil) {
+				return
+			}
+		}
+	}
+}
+
+// closeSource closes s with a context that isn't canceled with ctx, so that
+// the driver can still kill the cursor on the server after ctx is canceled.
+func closeSource(ctx context.Context, s Source) {
+	s.Close(context.WithoutCancel(ctx))
+}
@@ -0,0 +1,325 @@
+//go:build go1.23
+
+package cursoriter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type MyStruct struct {
+	MyProperty string
+}
+
+var errReset = errors.New("connection reset")
+
+// fakeCursor returns its documents in batches, and records the calls that
+// the iterators make.
+type fakeCursor struct {
+	t       *testing.T
+	batches [][]bson.D
+	// failAfter makes Next fail after that many documents, unless it's 0
+	failAfter int
+	// onNext runs before each call to Next returns
+	onNext func(calls int)
+
+	current   bson.D
+	err       error
+	nextCalls int
+	closes    []error
+}
+
+func newFakeCursor(t *testing.T, sizes ...int) *fakeCursor {
+	c := &fakeCursor{t: t}
+	n := 0
+	for _, size := range sizes {
+		var batch []bson.D
+		for i := 0; i < size; i++ {
+			batch = append(batch, bson.D{{"myproperty", fmt.Sprintf("doc %d", n)}})
+			n++
+		}
+		c.batches = append(c.batches, batch)
+	}
+	return c
+}
+
+func (c *fakeCursor) Next(ctx context.Context) bool {
+	c.nextCalls++
+	if c.onNext != nil {
+		defer c.onNext(c.nextCalls)
+	}
+	if c.closes != nil {
+		c.t.Error("Next() was called after Close()")
+	}
+	if c.failAfter > 0 && c.nextCalls > c.failAfter {
+		c.err = errReset
+		return false
+	}
+	for len(c.batches) > 0 && len(c.batches[0]) == 0 {
+		c.batches = c.batches[1:]
+	}
+	if len(c.batches) == 0 {
+		return false
+	}
+	c.current, c.batches[0] = c.batches[0][0], c.batches[0][1:]
+	return true
+}
+
+func (c *fakeCursor) Decode(val interface{}) error {
+	b, err := bson.Marshal(c.current)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(b, val)
+}
+
+func (c *fakeCursor) Err() error { return c.err }
+
+// Close records whether ctx was already canceled
+func (c *fakeCursor) Close(ctx context.Context) error {
+	c.closes = append(c.closes, ctx.Err())
+	return nil
+}
+
+func (c *fakeCursor) RemainingBatchLength() int {
+	if len(c.batches) == 0 {
+		return 0
+	}
+	return len(c.batches[0])
+}
+
+// checkClosed fails unless c was closed once with a context that wasn't
+// canceled.
+func checkClosed(t *testing.T, c *fakeCursor) {
+	t.Helper()
+	if len(c.closes) != 1 || c.closes[0] != nil {
+		t.Errorf("Close() calls: %v, want one with a live context", c.closes)
+	}
+}
+
+// check compares the printed forms of got and want.
+func check(t *testing.T, what string, got, want interface{}) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%s: got %v, want %v", what, got, want)
+	}
+}
+
+func properties(docs []MyStruct) []string {
+	var values []string
+	for _, doc := range docs {
+		values = append(values, doc.MyProperty)
+	}
+	return values
+}
+
+// collect reads seq to the end, and returns the values and the errors that
+// it yields.
+func collect[T any](seq iter.Seq2[T, error]) ([]T, []error) {
+	var values []T
+	var errs []error
+	for v, err := range seq {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		values = append(values, v)
+	}
+	return values, errs
+}
+
+func newDriverCursor(t *testing.T, err error) *mongo.Cursor {
+	t.Helper()
+	docs := []interface{}{MyStruct{"abc"}, MyStruct{"def"}, MyStruct{"ghi"}}
+	cursor, cerr := mongo.NewCursorFromDocuments(docs, err, nil)
+	if cerr != nil {
+		t.Fatal(cerr)
+	}
+	return cursor
+}
+
+func TestAllDriverCursor(t *testing.T) {
+	all, errs := collect(All[MyStruct](context.Background(), newDriverCursor(t, nil)))
+	check(t, "documents", properties(all), []string{"abc", "def", "ghi"})
+	check(t, "errors", errs, []error(nil))
+
+	_, errs = collect(All[MyStruct](context.Background(), newDriverCursor(t, errors.New("query failed"))))
+	check(t, "errors of a failed cursor", errs, []string{"query failed"})
+}
+
+func TestAll(t *testing.T) {
+	c := newFakeCursor(t, 2, 2, 1)
+	all, errs := collect(All[MyStruct](context.Background(), c))
+	check(t, "documents", properties(all), []string{"doc 0", "doc 1", "doc 2", "doc 3", "doc 4"})
+	check(t, "errors", errs, []error(nil))
+	checkClosed(t, c)
+}
+
+// TestAllBreak checks that a loop that ends early reads no further and
+// closes the cursor.
+func TestAllBreak(t *testing.T) {
+	c := newFakeCursor(t, 2, 2, 1)
+	var all []MyStruct
+	for doc, err := range All[MyStruct](context.Background(), c) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, doc)
+		if len(all) == 3 {
+			break
+		}
+	}
+	check(t, "documents", properties(all), []string{"doc 0", "doc 1", "doc 2"})
+	check(t, "Next calls", c.nextCalls, 3)
+	checkClosed(t, c)
+
+	c = newFakeCursor(t, 3)
+	first := func() string {
+		for doc, err := range All[MyStruct](context.Background(), c) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			return doc.MyProperty
+		}
+		return ""
+	}()
+	check(t, "returned document", first, "doc 0")
+	checkClosed(t, c)
+}
+
+// TestAllCancel checks that canceling the context stops the loop before the
+// next document, even in the middle of a batch.
+func TestAllCancel(t *testing.T) {
+	c := newFakeCursor(t, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var all []MyStruct
+	var errs []error
+	for doc, err := range All[MyStruct](ctx, c) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		all = append(all, doc)
+		if len(all) == 2 {
+			cancel()
+		}
+	}
+	check(t, "documents", properties(all), []string{"doc 0", "doc 1"})
+	check(t, "errors", errs, []error{context.Canceled})
+	checkClosed(t, c)
+
+	c = newFakeCursor(t, 5)
+	_, errs = collect(All[MyStruct](ctx, c))
+	check(t, "errors with a canceled context", errs, []error{context.Canceled})
+	check(t, "Next calls with a canceled context", c.nextCalls, 0)
+	checkClosed(t, c)
+}
+
+// TestAllCursorError checks that a cursor error ends the loop after the
+// documents before it.
+func TestAllCursorError(t *testing.T) {
+	c := newFakeCursor(t, 5)
+	c.failAfter = 2
+	all, errs := collect(All[MyStruct](context.Background(), c))
+	check(t, "documents", properties(all), []string{"doc 0", "doc 1"})
+	check(t, "errors", errs, []error{errReset})
+	checkClosed(t, c)
+}
+
+// TestAllDecodeError checks that a document that doesn't fit the type ends
+// the loop.
+func TestAllDecodeError(t *testing.T) {
+	c := newFakeCursor(t, 2)
+	_, errs := collect(All[struct{ MyProperty int }](context.Background(), c))
+	if len(errs) != 1 {
+		t.Errorf("got %v, want one error", errs)
+	}
+	check(t, "Next calls", c.nextCalls, 1)
+	checkClosed(t, c)
+}
+
+func TestAllConsumed(t *testing.T) {
+	c := newFakeCursor(t, 2)
+	seq := All[MyStruct](context.Background(), c)
+	collect(seq)
+	_, errs := collect(seq)
+	check(t, "errors of the second loop", errs, []error{ErrConsumed})
+	checkClosed(t, c)
+}
+
+// TestBatches checks that the batches follow the batches of the cursor.
+func TestBatches(t *testing.T) {
+	c := newFakeCursor(t, 2, 2, 1)
+	batches, errs := collect(Batches[MyStruct](context.Background(), c))
+	var got [][]string
+	for _, batch := range batches {
+		got = append(got, properties(batch))
+	}
+	check(t, "batches", got, [][]string{{"doc 0", "doc 1"}, {"doc 2", "doc 3"}, {"doc 4"}})
+	check(t, "errors", errs, []error(nil))
+	checkClosed(t, c)
+
+	batches, errs = collect(Batches[MyStruct](context.Background(), newDriverCursor(t, nil)))
+	got = nil
+	for _, batch := range batches {
+		got = append(got, properties(batch))
+	}
+	check(t, "driver cursor batches", got, [][]string{{"abc", "def", "ghi"}})
+	check(t, "driver cursor errors", errs, []error(nil))
+}
+
+func TestBatchesBreak(t *testing.T) {
+	c := newFakeCursor(t, 2, 2, 1)
+	var got [][]string
+	for batch, err := range Batches[MyStruct](context.Background(), c) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, properties(batch))
+		break
+	}
+	check(t, "batches", got, [][]string{{"doc 0", "doc 1"}})
+	check(t, "Next calls", c.nextCalls, 2)
+	checkClosed(t, c)
+}
+
+// TestBatchesCancel checks that canceling the context in the middle of a
+// batch discards the batch.
+func TestBatchesCancel(t *testing.T) {
+	c := newFakeCursor(t, 2, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.onNext = func(calls int) {
+		if calls == 4 {
+			cancel()
+		}
+	}
+	batches, errs := collect(Batches[MyStruct](ctx, c))
+	var got [][]string
+	for _, batch := range batches {
+		got = append(got, properties(batch))
+	}
+	check(t, "batches", got, [][]string{{"doc 0", "doc 1"}})
+	check(t, "errors", errs, []error{context.Canceled})
+	checkClosed(t, c)
+}
+
+func TestBatchesCursorError(t *testing.T) {
+	c := newFakeCursor(t, 2, 3)
+	c.failAfter = 3
+	batches, errs := collect(Batches[MyStruct](context.Background(), c))
+	var got [][]string
+	for _, batch := range batches {
+		got = append(got, properties(batch))
+	}
+	check(t, "batches", got, [][]string{{"doc 0", "doc 1"}, {"doc 2"}})
+	check(t, "errors", errs, []error{errReset})
+	checkClosed(t, c)
+}
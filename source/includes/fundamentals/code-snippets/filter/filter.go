@@ -0,0 +1,189 @@
+// Package filter builds query filters from typed operator constructors.
+//
+// A Condition is one query operator and its operand, such as {"$gt", 7}.
+// Where applies conditions to a field, and the logical functions combine
+// filters:
+//
+//	f := filter.Or(
+//		filter.Where("rating", filter.Gt(7), filter.Lte(10)),
+//		filter.Eq("type", "Oolong"),
+//	)
+//
+// Every function returns a bson.D value that you can pass to any method
+// that accepts a filter.
+package filter
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Condition is a query operator and its operand.
+type Condition bson.E
+
+// Where returns a filter that matches documents in which field meets every
+// condition.
+func Where(field string, conditions ...Condition) bson.D {
+	ops := make(bson.D, len(conditions))
+	for i, c := range conditions {
+		ops[i] = bson.E(c)
+	}
+	return bson.D{{field, ops}}
+}
+
+// Eq returns a filter that matches documents in which field equals value.
+// If field is an array, the filter also matches arrays that contain value.
+func Eq(field string, value interface{}) bson.D {
+	return bson.D{{field, value}}
+}
+
+// Merge combines filters on different fields into one filter. Documents
+// must match every filter. Use And to combine filters on the same field.
+func Merge(filters ...bson.D) bson.D {
+	var merged bson.D
+	for _, f := range filters {
+		merged = append(merged, f...)
+	}
+	return merged
+}
+
+func logical(operator string, filters []bson.D) bson.D {
+	list := make(bson.A, len(filters))
+	for i, f := range filters {
+		list[i] = f
+	}
+	return bson.D{{operator, list}}
+}
+
+// And returns a filter that matches documents that match every filter.
+func And(filters ...bson.D) bson.D {
+	return logical("$and", filters)
+}
+
+// Or returns a filter that matches documents that match at least one
+// filter.
+func Or(filters ...bson.D) bson.D {
+	return logical("$or", filters)
+}
+
+// Nor returns a filter that matches documents that match none of the
+// filters.
+func Nor(filters ...bson.D) bson.D {
+	return logical("$nor", filters)
+}
+
+// Not inverts a condition. The result also matches documents that don't
+// contain the field.
+func Not(c Condition) Condition {
+	return Condition{"$not", bson.D{bson.E(c)}}
+}
+
+// Equal matches a value that equals value. Eq is shorter when equality is
+// the only condition on a field.
+func Equal(value interface{}) Condition {
+	return Condition{"$eq", value}
+}
+
+// Ne matches a value that doesn't equal value.
+func Ne(value interface{}) Condition {
+	return Condition{"$ne", value}
+}
+
+// Gt matches a value greater than value.
+func Gt(value interface{}) Condition {
+	return Condition{"$gt", value}
+}
+
+// Gte matches a value greater than or equal to value.
+func Gte(value interface{}) Condition {
+	return Condition{"$gte", value}
+}
+
+// Lt matches a value less than value.
+func Lt(value interface{}) Condition {
+	return Condition{"$lt", value}
+}
+
+// Lte matches a value less than or equal to value.
+func Lte(value interface{}) Condition {
+	return Condition{"$lte", value}
+}
+
+// In matches a value that equals any of values.
+func In(values ...interface{}) Condition {
+	return Condition{"$in", bson.A(values)}
+}
+
+// Nin matches a value that equals none of values.
+func Nin(values ...interface{}) Condition {
+	return Condition{"$nin", bson.A(values)}
+}
+
+// Exists matches documents that contain the field if exists is true, and
+// documents that don't contain it if exists is false.
+func Exists(exists bool) Condition {
+	return Condition{"$exists", exists}
+}
+
+// Type matches values of a BSON type. Pass a type alias such as "string"
+// or a type number.
+func Type(t interface{}) Condition {
+	return Condition{"$type", t}
+}
+
+// All matches arrays that contain every one of values.
+func All(values ...interface{}) Condition {
+	return Condition{"$all", bson.A(values)}
+}
+
+// Size matches arrays with n elements.
+func Size(n int) Condition {
+	return Condition{"$size", n}
+}
+
+// ElemMatch matches arrays that contain an element that matches query. For
+// an array of documents, query is a filter on the fields of the elements.
+// For an array of values, build query from conditions, such as
+// bson.D{bson.E(filter.Gte(80))}.
+func ElemMatch(query bson.D) Condition {
+	return Condition{"$elemMatch", query}
+}
+
+// Regex matches strings that match a regular expression.
+func Regex(pattern string) Condition {
+	return Condition{"$regex", pattern}
+}
+
+// RegexWithOptions matches strings that match a regular expression with
+// options, such as "i" for a case-insensitive match.
+func RegexWithOptions(pattern, options string) Condition {
+	return Condition{"$regex", primitive.Regex{Pattern: pattern, Options: options}}
+}
+
+// Mod matches numbers that have remainder when divided by divisor.
+func Mod(divisor, remainder int) Condition {
+	return Condition{"$mod", bson.A{divisor, remainder}}
+}
+
+// BitsAllSet matches numbers in which every bit of mask is set.
+func BitsAllSet(mask interface{}) Condition {
+	return Condition{"$bitsAllSet", mask}
+}
+
+// BitsAnySet matches numbers in which at least one bit of mask is set.
+func BitsAnySet(mask interface{}) Condition {
+	return Condition{"$bitsAnySet", mask}
+}
+
+// Expr returns a filter that matches documents for which an aggregation
+// expression is true. The expression can compare fields of the same
+// document.
+func Expr(expression interface{}) bson.D {
+	return bson.D{{"$expr", expression}}
+}
+
+// Text returns a filter that runs a text search. The collection must have
+// a text index.
+func Text(search string) bson.D {
+	return bson.D{{"$text", bson.D{{"$search", search}}}}
+}
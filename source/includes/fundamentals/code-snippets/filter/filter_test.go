@@ -0,0 +1,91 @@
+package filter
+
+import (
+	"bytes"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// marshal returns the BSON bytes of doc.
+func marshal(t *testing.T, doc bson.D) []byte {
+	t.Helper()
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestFilters compares the filters that the CRUD guides and usage examples
+// write as literals, and one filter for each other operator, with the
+// documents that the builders return.
+func TestFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder bson.D
+		want    bson.D
+	}{
+		{"implicit equality", Eq("type", "Oolong"),
+			bson.D{{"type", "Oolong"}}},
+		{"$eq", Where("type", Equal("Oolong")),
+			bson.D{{"type", bson.D{{"$eq", "Oolong"}}}}},
+		{"$ne", Where("type", Ne("Oolong")),
+			bson.D{{"type", bson.D{{"$ne", "Oolong"}}}}},
+		{"$lt", Where("rating", Lt(7)),
+			bson.D{{"rating", bson.D{{"$lt", 7}}}}},
+		{"$gt", Where("length", Gt(300)),
+			bson.D{{"length", bson.D{{"$gt", 300}}}}},
+		{"$gte and $lte", Where("rating", Gte(5), Lte(9)),
+			bson.D{{"rating", bson.D{{"$gte", 5}, {"$lte", 9}}}}},
+		{"$in", Where("type", In("Masala", "Sencha")),
+			bson.D{{"type", bson.D{{"$in", bson.A{"Masala", "Sencha"}}}}}},
+		{"$nin", Where("type", Nin("Masala", "Sencha")),
+			bson.D{{"type", bson.D{{"$nin", bson.A{"Masala", "Sencha"}}}}}},
+		{"$and", And(Where("rating", Gt(7)), Where("rating", Lte(10))),
+			bson.D{{"$and", bson.A{
+				bson.D{{"rating", bson.D{{"$gt", 7}}}},
+				bson.D{{"rating", bson.D{{"$lte", 10}}}},
+			}}}},
+		{"$or", Or(Eq("ns.coll", "orders"), Eq("ns.coll", "customers")),
+			bson.D{{"$or", bson.A{bson.D{{"ns.coll", "orders"}}, bson.D{{"ns.coll", "customers"}}}}}},
+		{"$nor", Nor(Eq("type", "Oolong"), Where("rating", Lt(5))),
+			bson.D{{"$nor", bson.A{bson.D{{"type", "Oolong"}}, bson.D{{"rating", bson.D{{"$lt", 5}}}}}}}},
+		{"$not", Where("type", Not(Regex("^E"))),
+			bson.D{{"type", bson.D{{"$not", bson.D{{"$regex", "^E"}}}}}}},
+		{"merged fields", Merge(Eq("species", "Ledebouria socialis"), Eq("plant_id", 3)),
+			bson.D{{"species", "Ledebouria socialis"}, {"plant_id", 3}}},
+		{"$exists", Where("vendor", Exists(false)),
+			bson.D{{"vendor", bson.D{{"$exists", false}}}}},
+		{"$type", Where("rating", Type("int")),
+			bson.D{{"rating", bson.D{{"$type", "int"}}}}},
+		{"$all", Where("vendor", All("C")),
+			bson.D{{"vendor", bson.D{{"$all", bson.A{"C"}}}}}},
+		{"$size", Where("vendor", Size(2)),
+			bson.D{{"vendor", bson.D{{"$size", 2}}}}},
+		{"$elemMatch", Where("sizes", ElemMatch(bson.D{bson.E(Gte(12)), bson.E(Lt(16))})),
+			bson.D{{"sizes", bson.D{{"$elemMatch", bson.D{{"$gte", 12}, {"$lt", 16}}}}}}},
+		{"$regex", Where("type", Regex("^E")),
+			bson.D{{"type", bson.D{{"$regex", "^E"}}}}},
+		{"$regex with options", Where("type", RegexWithOptions("^e", "i")),
+			bson.D{{"type", bson.D{{"$regex", primitive.Regex{Pattern: "^e", Options: "i"}}}}}},
+		{"$mod", Where("rating", Mod(2, 0)),
+			bson.D{{"rating", bson.D{{"$mod", bson.A{2, 0}}}}}},
+		{"$bitsAllSet", Where("rating", BitsAllSet(6)),
+			bson.D{{"rating", bson.D{{"$bitsAllSet", 6}}}}},
+		{"$bitsAnySet", Where("rating", BitsAnySet(6)),
+			bson.D{{"rating", bson.D{{"$bitsAnySet", 6}}}}},
+		{"$expr", Expr(bson.D{{"$gt", bson.A{"$sold", "$stock"}}}),
+			bson.D{{"$expr", bson.D{{"$gt", bson.A{"$sold", "$stock"}}}}}},
+		{"$text", Text("green tea"),
+			bson.D{{"$text", bson.D{{"$search", "green tea"}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !bytes.Equal(marshal(t, tt.builder), marshal(t, tt.want)) {
+				t.Errorf("got %v, want %v", tt.builder, tt.want)
+			}
+		})
+	}
+}
@@ -1,48 +1,302 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func main() {
-	// Create a new client and connect to the server
-	client, err := mongo.Connect(context.TODO())
+	var uri string
+	if uri = os.Getenv("MONGODB_URI"); uri == "" {
+		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/")
+	}
 
+	// Create a new client and connect to the server
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
 	if err != nil {
 		panic(err)
 	}
-	defer client.Disconnect(context.TODO())
+	defer func() {
+		if err = client.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
 
-	bucket, err := gridfs.NewBucket(client.Database("foo"))
+	// Write two versions of a sample file to upload
+	dir, err := ioutil.TempDir("", "gridfs-example")
 	if err != nil {
 		panic(err)
 	}
+	defer os.RemoveAll(dir)
 
-	// begin OpenUploadStream example
-	file, err := os.Open("path/to/file.txt")
-	if err != nil {
+	firstRevision := []byte(strings.Repeat("MongoDB GridFS sample content, first revision.\n", 10000))
+	secondRevision := []byte(strings.Repeat("MongoDB GridFS sample content, second revision.\n", 100))
+	if err := ioutil.WriteFile(filepath.Join(dir, "file.txt"), firstRevision, 0600); err != nil {
 		panic(err)
 	}
-	uploadOpts := options.GridFSUpload().SetChunkSizeBytes(200000)
-	uploadStream, err := bucket.OpenUploadStream("file.txt", uploadOpts)
-	if err != nil {
+	if err := ioutil.WriteFile(filepath.Join(dir, "file-v2.txt"), secondRevision, 0600); err != nil {
 		panic(err)
 	}
-	fileContent, err := io.ReadAll(file)
+
+	// begin create bucket
+	db := client.Database("myDB")
+	bucket, err := gridfs.NewBucket(db)
 	if err != nil {
 		panic(err)
 	}
-	var bytes int
-	if bytes, err = uploadStream.Write(fileContent); err != nil {
+	// end create bucket
+
+	// Start from an empty bucket
+	if err := bucket.Drop(); err != nil {
 		panic(err)
 	}
-	fmt.Printf("New file uploaded with %d bytes written", bytes)
-	// end OpenUploadStream example
+
+	var firstID primitive.ObjectID
+	{
+		// begin OpenUploadStream example
+		file, err := os.Open(filepath.Join(dir, "file.txt"))
+		if err != nil {
+			panic(err)
+		}
+		defer file.Close()
+
+		uploadOpts := options.GridFSUpload().
+			SetChunkSizeBytes(200000).
+			SetMetadata(bson.D{{"metadata tag", "first"}})
+		uploadStream, err := bucket.OpenUploadStream("file.txt", uploadOpts)
+		if err != nil {
+			panic(err)
+		}
+
+		// io.Copy streams the file into GridFS one chunk at a time
+		bytesWritten, err := io.Copy(uploadStream, file)
+		if err != nil {
+			uploadStream.Abort()
+			panic(err)
+		}
+		// Close flushes the last chunk and writes the files collection document
+		if err := uploadStream.Close(); err != nil {
+			panic(err)
+		}
+		fmt.Printf("New file uploaded with %d bytes written\n", bytesWritten)
+		// end OpenUploadStream example
+
+		firstID = uploadStream.FileID.(primitive.ObjectID)
+	}
+
+	var secondID primitive.ObjectID
+	{
+		// begin UploadFromStream example
+		file, err := os.Open(filepath.Join(dir, "file-v2.txt"))
+		if err != nil {
+			panic(err)
+		}
+		defer file.Close()
+
+		uploadOpts := options.GridFSUpload().SetMetadata(bson.D{{"metadata tag", "second"}})
+
+		// Uploading a file with an existing name creates a new revision
+		objectID, err := bucket.UploadFromStream("file.txt", io.Reader(file), uploadOpts)
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("New file uploaded with ID %s\n", objectID)
+		// end UploadFromStream example
+
+		secondID = objectID
+	}
+
+	fmt.Println("\nFind files:")
+	{
+		// begin find files
+		filter := bson.D{{"length", bson.D{{"$gt", 1500}}}}
+		cursor, err := bucket.Find(filter)
+		if err != nil {
+			panic(err)
+		}
+
+		type gridfsFile struct {
+			Name   string `bson:"filename"`
+			Length int64  `bson:"length"`
+		}
+		var foundFiles []gridfsFile
+		if err = cursor.All(context.TODO(), &foundFiles); err != nil {
+			panic(err)
+		}
+
+		for _, file := range foundFiles {
+			fmt.Printf("filename: %s, length: %d\n", file.Name, file.Length)
+		}
+		// end find files
+	}
+
+	fmt.Println("\nFind files by metadata:")
+	{
+		// begin find by metadata
+		filter := bson.D{{"metadata.metadata tag", "second"}}
+		opts := options.GridFSFind().SetSort(bson.D{{"uploadDate", -1}})
+		cursor, err := bucket.Find(filter, opts)
+		if err != nil {
+			panic(err)
+		}
+
+		var foundFiles []bson.M
+		if err = cursor.All(context.TODO(), &foundFiles); err != nil {
+			panic(err)
+		}
+
+		for _, file := range foundFiles {
+			fmt.Printf("_id: %v, uploadDate: %v, metadata: %v\n", file["_id"], file["uploadDate"], file["metadata"])
+		}
+		// end find by metadata
+	}
+
+	fmt.Println("\nDownload by ID:")
+	{
+		id := firstID
+		// begin download to stream
+		fileBuffer := bytes.NewBuffer(nil)
+		if _, err := bucket.DownloadToStream(id, fileBuffer); err != nil {
+			panic(err)
+		}
+		// end download to stream
+
+		verify("DownloadToStream", fileBuffer.Bytes(), firstRevision)
+	}
+
+	{
+		id := firstID
+		// begin open download stream
+		downloadStream, err := bucket.OpenDownloadStream(id)
+		if err != nil {
+			panic(err)
+		}
+		defer downloadStream.Close()
+
+		// Copy the file to a local file one chunk at a time, without
+		// loading the whole file into memory
+		localFile, err := os.Create(filepath.Join(dir, "file-copy.txt"))
+		if err != nil {
+			panic(err)
+		}
+		if _, err := io.Copy(localFile, downloadStream); err != nil {
+			panic(err)
+		}
+		if err := localFile.Close(); err != nil {
+			panic(err)
+		}
+		// end open download stream
+
+		copied, err := ioutil.ReadFile(filepath.Join(dir, "file-copy.txt"))
+		if err != nil {
+			panic(err)
+		}
+		verify("OpenDownloadStream", copied, firstRevision)
+	}
+
+	fmt.Println("\nDownload by name and revision:")
+	{
+		// begin download by name
+		// Revision 0 is the original file, and -1 is the most recent revision
+		fileBuffer := bytes.NewBuffer(nil)
+		nameOpts := options.GridFSName().SetRevision(0)
+		if _, err := bucket.DownloadToStreamByName("file.txt", fileBuffer, nameOpts); err != nil {
+			panic(err)
+		}
+		// end download by name
+
+		verify("revision 0", fileBuffer.Bytes(), firstRevision)
+
+		fileBuffer.Reset()
+		downloadStream, err := bucket.OpenDownloadStreamByName("file.txt", options.GridFSName().SetRevision(-1))
+		if err != nil {
+			panic(err)
+		}
+		if _, err := io.Copy(fileBuffer, downloadStream); err != nil {
+			panic(err)
+		}
+		downloadStream.Close()
+
+		verify("revision -1", fileBuffer.Bytes(), secondRevision)
+	}
+
+	fmt.Println("\nRename:")
+	{
+		id := secondID
+		// begin rename
+		if err := bucket.Rename(id, "mongodbTutorial.zip"); err != nil {
+			panic(err)
+		}
+		// end rename
+
+		var file bson.M
+		if err := db.Collection("fs.files").FindOne(context.TODO(), bson.D{{"_id", id}}).Decode(&file); err != nil {
+			panic(err)
+		}
+		fmt.Printf("File %s is now named %s\n", id.Hex(), file["filename"])
+	}
+
+	fmt.Println("\nDelete:")
+	{
+		id := firstID
+		// begin delete
+		if err := bucket.Delete(id); err != nil {
+			panic(err)
+		}
+		// end delete
+
+		// Delete removes the files document and every chunk of the file
+		count, err := db.Collection("fs.chunks").CountDocuments(context.TODO(), bson.D{{"files_id", id}})
+		if err != nil {
+			panic(err)
+		}
+		if count != 0 {
+			log.Fatalf("expected no chunks for file %s, found %d", id.Hex(), count)
+		}
+		if _, err := bucket.OpenDownloadStream(id); err != gridfs.ErrFileNotFound {
+			log.Fatalf("expected %v, got %v", gridfs.ErrFileNotFound, err)
+		}
+		fmt.Printf("Deleted file %s and its chunks\n", id.Hex())
+	}
+
+	fmt.Println("\nDrop bucket:")
+	{
+		// begin drop
+		if err := bucket.Drop(); err != nil {
+			panic(err)
+		}
+		// end drop
+
+		names, err := db.ListCollectionNames(context.TODO(), bson.D{{"name", bson.D{{"$regex", "^fs\\."}}}})
+		if err != nil {
+			panic(err)
+		}
+		if len(names) != 0 {
+			log.Fatalf("expected the bucket collections to be dropped, found %v", names)
+		}
+		fmt.Println("Dropped the fs.files and fs.chunks collections")
+	}
+}
+
+// verify stops the program if the downloaded content doesn't match the
+// uploaded content.
+func verify(operation string, downloaded, uploaded []byte) {
+	if !bytes.Equal(downloaded, uploaded) {
+		log.Fatalf("%s: downloaded %d bytes that don't match the %d uploaded bytes", operation, len(downloaded), len(uploaded))
+	}
+	fmt.Printf("%s: downloaded %d bytes matching the uploaded file\n", operation, len(downloaded))
 }
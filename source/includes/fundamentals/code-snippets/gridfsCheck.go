@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fileDoc is a document in the files collection of a bucket.
+type fileDoc struct {
+	ID         interface{} `bson:"_id"`
+	Name       string      `bson:"filename"`
+	Length     int64       `bson:"length"`
+	ChunkSize  int32       `bson:"chunkSize"`
+	UploadDate time.Time   `bson:"uploadDate"`
+}
+
+// chunkSummary describes the chunks that reference one files_id value.
+type chunkSummary struct {
+	FilesID   interface{} `bson:"_id"`
+	Count     int64       `bson:"count"`
+	MinN      int64       `bson:"minN"`
+	MaxN      int64       `bson:"maxN"`
+	TotalSize int64       `bson:"totalSize"`
+	MaxSize   int64       `bson:"maxSize"`
+	// Newest is the largest chunk _id, which is an ObjectID for chunks that
+	// the drivers write
+	Newest interface{} `bson:"newest"`
+}
+
+type checker struct {
+	files     *mongo.Collection
+	chunks    *mongo.Collection
+	batchSize int
+	minAge    time.Duration
+	dryRun    bool
+}
+
+func main() {
+	uri := flag.String("uri", os.Getenv("MONGODB_URI"), "the connection string, defaults to $MONGODB_URI")
+	dbName := flag.String("db", "test", "the database that contains the bucket")
+	bucketName := flag.String("bucket", "fs", "the bucket name")
+	deleteOrphans := flag.Bool("delete-orphans", false, "delete chunks that don't belong to any file")
+	deleteBroken := flag.Bool("delete-broken", false, "delete files that have missing or inconsistent chunks, and their chunks")
+	recoverOrphans := flag.Bool("recover-orphans", false, "create a files document for orphan chunks that form a complete sequence")
+	batchSize := flag.Int("batch-size", 100, "the number of files_id values to delete or recover in each batch")
+	minAge := flag.Duration("min-age", time.Hour, "ignore orphan chunks written more recently than this, which can belong to an upload in progress")
+	dryRun := flag.Bool("dry-run", true, "report the changes without writing them, set -dry-run=false to apply them")
+	flag.Parse()
+
+	if *uri == "" {
+		log.Fatal("You must pass a connection string with -uri or set your 'MONGODB_URI' environmental variable.")
+	}
+	if *deleteOrphans && *recoverOrphans {
+		log.Fatal("-delete-orphans and -recover-orphans can't be used together")
+	}
+	if *batchSize <= 0 {
+		log.Fatalf("-batch-size must be positive, not %d", *batchSize)
+	}
+	if *minAge < 0 {
+		log.Fatalf("-min-age can't be negative, not %s", *minAge)
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(*uri))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Disconnect(context.TODO())
+
+	db := client.Database(*dbName)
+	c := &checker{
+		files:     db.Collection(*bucketName + ".files"),
+		chunks:    db.Collection(*bucketName + ".chunks"),
+		batchSize: *batchSize,
+		minAge:    *minAge,
+		dryRun:    *dryRun,
+	}
+
+	files, err := c.loadFiles()
+	if err != nil {
+		log.Fatal(err)
+	}
+	summaries, err := c.summarizeChunks()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var broken []fileDoc
+	var orphans []chunkSummary
+	pending := 0
+	cutoff := time.Now().Add(-c.minAge)
+	for _, summary := range summaries {
+		key := idKey(summary.FilesID)
+		file, ok := files[key]
+		if !ok {
+			// An upload writes its files document after the last chunk
+			if newest, ok := summary.Newest.(primitive.ObjectID); ok && newest.Timestamp().After(cutoff) {
+				pending++
+				fmt.Printf("PENDING files_id %v: %d chunks, the newest written at %s, can belong to an upload in progress\n",
+					summary.FilesID, summary.Count, newest.Timestamp().Format(time.RFC3339))
+				continue
+			}
+			orphans = append(orphans, summary)
+			fmt.Printf("ORPHAN  files_id %v: %d chunks, %d bytes, no files document\n", summary.FilesID, summary.Count, summary.TotalSize)
+			continue
+		}
+		delete(files, key)
+		if problem := verify(file, summary); problem != "" {
+			broken = append(broken, file)
+			fmt.Printf("BROKEN  %v (%s): %s\n", file.ID, file.Name, problem)
+		}
+	}
+	// Any file left in the map has no chunks at all
+	for _, file := range files {
+		if file.Length > 0 {
+			broken = append(broken, file)
+			fmt.Printf("BROKEN  %v (%s): expected %d chunks, found none\n", file.ID, file.Name, expectedChunks(file))
+		}
+	}
+
+	fmt.Printf("\nChecked %d files document(s) and %d files_id value(s) in the chunks collection\n",
+		len(summaries)-len(orphans)-pending+len(files), len(summaries))
+	fmt.Printf("%d broken file(s), %d orphan chunk group(s), %d chunk group(s) younger than -min-age\n",
+		len(broken), len(orphans), pending)
+
+	if c.dryRun && (*deleteOrphans || *deleteBroken || *recoverOrphans) {
+		fmt.Println("\nDry run, no changes will be written. Set -dry-run=false to apply them.")
+	}
+	if *deleteOrphans {
+		ids := make([]interface{}, len(orphans))
+		for i, orphan := range orphans {
+			ids[i] = orphan.FilesID
+		}
+		if err := c.deleteOrphans(ids); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *recoverOrphans {
+		if err := c.recoverFiles(orphans); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *deleteBroken {
+		if err := c.deleteFiles(broken); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(broken) > 0 || len(orphans) > 0 {
+		os.Exit(1)
+	}
+}
+
+// loadFiles returns every document in the files collection, keyed by ID.
+func (c *checker) loadFiles() (map[string]fileDoc, error) {
+	cursor, err := c.files.Find(context.TODO(), bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+
+	files := make(map[string]fileDoc)
+	for cursor.Next(context.TODO()) {
+		var file fileDoc
+		if err := cursor.Decode(&file); err != nil {
+			return nil, err
+		}
+		files[idKey(file.ID)] = file
+	}
+	return files, cursor.Err()
+}
+
+// begin summarize chunks
+// summarizeChunks groups the chunks collection by files_id and returns the
+// number of chunks, the range of sequence numbers, and the total data size
+// of each group.
+func (c *checker) summarizeChunks() ([]chunkSummary, error) {
+	groupStage := bson.D{{"$group", bson.D{
+		{"_id", "$files_id"},
+		{"count", bson.D{{"$sum", 1}}},
+		{"minN", bson.D{{"$min", "$n"}}},
+		{"maxN", bson.D{{"$max", "$n"}}},
+		{"totalSize", bson.D{{"$sum", bson.D{{"$binarySize", "$data"}}}}},
+		{"maxSize", bson.D{{"$max", bson.D{{"$binarySize", "$data"}}}}},
+		{"newest", bson.D{{"$max", "$_id"}}},
+	}}}
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cursor, err := c.chunks.Aggregate(context.TODO(), mongo.Pipeline{groupStage}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var summaries []chunkSummary
+	if err := cursor.All(context.TODO(), &summaries); err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
+
+// end summarize chunks
+
+// begin verify file
+// verify compares a files document with the summary of its chunks and
+// describes the first inconsistency it finds.
+func verify(file fileDoc, chunks chunkSummary) string {
+	expected := expectedChunks(file)
+	switch {
+	case chunks.Count != expected:
+		return fmt.Sprintf("expected %d chunks, found %d", expected, chunks.Count)
+	case chunks.MinN != 0 || chunks.MaxN != chunks.Count-1:
+		// The unique index on files_id and n prevents duplicate sequence
+		// numbers, so any gap shows up as a range wider than the count
+		return fmt.Sprintf("chunk sequence numbers %d to %d are not continuous", chunks.MinN, chunks.MaxN)
+	case chunks.TotalSize != file.Length:
+		return fmt.Sprintf("chunks contain %d bytes, but the file length is %d", chunks.TotalSize, file.Length)
+	case chunks.MaxSize > int64(file.ChunkSize):
+		return fmt.Sprintf("a chunk contains %d bytes, more than the chunk size of %d", chunks.MaxSize, file.ChunkSize)
+	}
+	return ""
+}
+
+// end verify file
+
+func expectedChunks(file fileDoc) int64 {
+	if file.ChunkSize <= 0 {
+		return 0
+	}
+	return (file.Length + int64(file.ChunkSize) - 1) / int64(file.ChunkSize)
+}
+
+// begin delete chunks
+// deleteChunks deletes the chunks of the given files_id values in batches,
+// so that no single delete holds locks for a long time.
+func (c *checker) deleteChunks(ids []interface{}) error {
+	for start := 0; start < len(ids); start += c.batchSize {
+		end := start + c.batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		filter := bson.D{{"files_id", bson.D{{"$in", ids[start:end]}}}}
+
+		if c.dryRun {
+			count, err := c.chunks.CountDocuments(context.TODO(), filter)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("would delete %d chunks of %d files_id value(s)\n", count, end-start)
+			continue
+		}
+		result, err := c.chunks.DeleteMany(context.TODO(), filter)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("deleted %d chunks of %d files_id value(s)\n", result.DeletedCount, end-start)
+	}
+	return nil
+}
+
+// end delete chunks
+
+// deleteOrphans deletes the chunks of the given files_id values in batches.
+// Just before it deletes each batch, it looks for files documents again and
+// keeps the chunks of any upload that finished since the check.
+func (c *checker) deleteOrphans(ids []interface{}) error {
+	for start := 0; start < len(ids); start += c.batchSize {
+		end := start + c.batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := ids[start:end]
+
+		filter := bson.D{{"_id", bson.D{{"$in", batch}}}}
+		opts := options.Find().SetProjection(bson.D{{"_id", 1}})
+		var found []fileDoc
+		cursor, err := c.files.Find(context.TODO(), filter, opts)
+		if err != nil {
+			return err
+		}
+		if err := cursor.All(context.TODO(), &found); err != nil {
+			return err
+		}
+		if len(found) > 0 {
+			existing := make(map[string]bool, len(found))
+			for _, file := range found {
+				existing[idKey(file.ID)] = true
+				fmt.Printf("keeping the chunks of files_id %v, which now has a files document\n", file.ID)
+			}
+			var remaining []interface{}
+			for _, id := range batch {
+				if !existing[idKey(id)] {
+					remaining = append(remaining, id)
+				}
+			}
+			batch = remaining
+		}
+		if len(batch) == 0 {
+			continue
+		}
+
+		if err := c.deleteChunks(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteFiles deletes the files documents of broken files and then their
+// chunks. Deleting the files document first hides the file from readers
+// before its chunks disappear.
+func (c *checker) deleteFiles(files []fileDoc) error {
+	ids := make([]interface{}, len(files))
+	for i, file := range files {
+		ids[i] = file.ID
+	}
+	for start := 0; start < len(ids); start += c.batchSize {
+		end := start + c.batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		filter := bson.D{{"_id", bson.D{{"$in", ids[start:end]}}}}
+
+		if c.dryRun {
+			fmt.Printf("would delete %d files document(s)\n", end-start)
+			continue
+		}
+		result, err := c.files.DeleteMany(context.TODO(), filter)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("deleted %d files document(s)\n", result.DeletedCount)
+	}
+	return c.deleteChunks(ids)
+}
+
+// recoverFiles inserts a files document for each group of orphan chunks
+// whose sequence numbers run from 0 to count-1 and whose chunks, except the
+// last one, all have the same size, so that the data can be downloaded
+// again. Other groups are left for -delete-orphans.
+func (c *checker) recoverFiles(orphans []chunkSummary) error {
+	var docs []interface{}
+	for _, orphan := range orphans {
+		chunkSize, problem, err := c.checkSequence(orphan.FilesID)
+		if err != nil {
+			return err
+		}
+		if problem != "" {
+			fmt.Printf("can't recover files_id %v: %s\n", orphan.FilesID, problem)
+			continue
+		}
+		docs = append(docs, bson.D{
+			{"_id", orphan.FilesID},
+			{"length", orphan.TotalSize},
+			{"chunkSize", chunkSize},
+			{"uploadDate", time.Now()},
+			{"filename", fmt.Sprintf("recovered-%v", orphan.FilesID)},
+			{"metadata", bson.D{{"recovered", true}}},
+		})
+	}
+
+	for start := 0; start < len(docs); start += c.batchSize {
+		end := start + c.batchSize
+		if end > len(docs) {
+			end = len(docs)
+		}
+		if c.dryRun {
+			fmt.Printf("would recover %d file(s)\n", end-start)
+			continue
+		}
+		result, err := c.files.InsertMany(context.TODO(), docs[start:end])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("recovered %d file(s)\n", len(result.InsertedIDs))
+	}
+	return nil
+}
+
+// checkSequence reads the sequence number and size of each chunk of a
+// files_id value in order. It returns the chunk size of the file, or
+// describes why the chunks don't form a complete file. A bucket without the
+// unique index on files_id and n can contain duplicate sequence numbers,
+// which the minimum and maximum in the summary don't reveal.
+func (c *checker) checkSequence(filesID interface{}) (int64, string, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"files_id", filesID}}}},
+		{{"$sort", bson.D{{"n", 1}}}},
+		{{"$project", bson.D{{"_id", 0}, {"n", 1}, {"size", bson.D{{"$binarySize", "$data"}}}}}},
+	}
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cursor, err := c.chunks.Aggregate(context.TODO(), pipeline, opts)
+	if err != nil {
+		return 0, "", err
+	}
+	defer cursor.Close(context.TODO())
+
+	var chunkSize, lastSize int64
+	var expectedN int64
+	for cursor.Next(context.TODO()) {
+		var chunk struct {
+			N    int64 `bson:"n"`
+			Size int64 `bson:"size"`
+		}
+		if err := cursor.Decode(&chunk); err != nil {
+			return 0, "", err
+		}
+		if chunk.N != expectedN {
+			return 0, fmt.Sprintf("expected chunk %d, found chunk %d", expectedN, chunk.N), nil
+		}
+		// Every chunk before this one must be full
+		if expectedN == 0 {
+			chunkSize = chunk.Size
+		} else if lastSize != chunkSize {
+			return 0, fmt.Sprintf("chunk %d contains %d bytes, but chunk 0 contains %d", expectedN-1, lastSize, chunkSize), nil
+		}
+		if chunk.Size > chunkSize {
+			return 0, fmt.Sprintf("chunk %d contains %d bytes, more than chunk 0", chunk.N, chunk.Size), nil
+		}
+		lastSize = chunk.Size
+		expectedN++
+	}
+	if err := cursor.Err(); err != nil {
+		return 0, "", err
+	}
+	if expectedN == 0 {
+		return 0, "the chunks were deleted", nil
+	}
+	if chunkSize == 0 {
+		return 0, "chunk 0 is empty", nil
+	}
+	return chunkSize, "", nil
+}
+
+// idKey returns a string that identifies a files_id value of any type.
+func idKey(id interface{}) string {
+	return fmt.Sprintf("%T:%v", id, id)
+}
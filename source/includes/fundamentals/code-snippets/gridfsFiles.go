@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const usage = `Usage: gridfsFiles [flags] <command> [arguments]
+
+Commands:
+  put <filename> [local file]      upload a file, or standard input if no local file is given
+  get <filename> [local file]      download a file, or write it to standard output
+  list [prefix]                    list files whose names start with prefix
+  search <key=value>...            list files whose metadata matches every key=value pair
+  delete <filename>                delete every revision of a file
+  rename <filename> <new name>     rename every revision of a file
+  stats                            print the number and size of files in the bucket
+
+With -id, get, delete, and rename take the ID of one file instead of a
+filename. A 24-character hex ID is an ObjectID, and any other ID is a string.
+
+Flags:
+`
+
+// metadataFlag collects repeated -metadata key=value flags.
+type metadataFlag bson.D
+
+func (m *metadataFlag) String() string {
+	return fmt.Sprint(*m)
+}
+
+func (m *metadataFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("metadata must have the form key=value, got %q", value)
+	}
+	*m = append(*m, bson.E{Key: parts[0], Value: parts[1]})
+	return nil
+}
+
+// gridfsFile is a document in the files collection of a bucket.
+// The _id is an ObjectID for files that UploadFromStream() creates, but
+// UploadFromStreamWithID() accepts an ID of any type.
+type gridfsFile struct {
+	ID         bson.RawValue `bson:"_id"`
+	Name       string        `bson:"filename"`
+	Length     int64         `bson:"length"`
+	ChunkSize  int32         `bson:"chunkSize"`
+	UploadDate time.Time     `bson:"uploadDate"`
+	Metadata   bson.M        `bson:"metadata,omitempty"`
+}
+
+// formatID returns the hex string of an ObjectID, and the Extended JSON of
+// any other ID.
+func formatID(id bson.RawValue) string {
+	if oid, ok := id.ObjectIDOK(); ok {
+		return oid.Hex()
+	}
+	return id.String()
+}
+
+// parseID returns the ID that the -id flag refers to.
+func parseID(s string) bson.RawValue {
+	var id interface{} = s
+	if oid, err := primitive.ObjectIDFromHex(s); err == nil {
+		id = oid
+	}
+	t, data, err := bson.MarshalValue(id)
+	if err != nil {
+		panic(err)
+	}
+	return bson.RawValue{Type: t, Value: data}
+}
+
+// progressReader reports the number of bytes read from the underlying
+// reader to standard error, at most once per interval.
+type progressReader struct {
+	r        io.Reader
+	label    string
+	total    int64
+	read     int64
+	lastShow time.Time
+	enabled  bool
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if p.enabled && (time.Since(p.lastShow) > 500*time.Millisecond || err == io.EOF) {
+		p.lastShow = time.Now()
+		if p.total > 0 {
+			fmt.Fprintf(os.Stderr, "\r%s: %d/%d bytes (%.0f%%)", p.label, p.read, p.total, 100*float64(p.read)/float64(p.total))
+		} else {
+			fmt.Fprintf(os.Stderr, "\r%s: %d bytes", p.label, p.read)
+		}
+		if err == io.EOF {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+	return n, err
+}
+
+func main() {
+	uri := flag.String("uri", os.Getenv("MONGODB_URI"), "the connection string, defaults to $MONGODB_URI")
+	dbName := flag.String("db", "test", "the database that contains the bucket")
+	bucketName := flag.String("bucket", "fs", "the bucket name, which prefixes the files and chunks collections")
+	chunkSize := flag.Int("chunk-size", 255*1024, "the chunk size in bytes for uploaded files")
+	revision := flag.Int("revision", -1, "the revision to get by name, where 0 is the original and -1 the most recent")
+	progress := flag.Bool("progress", false, "report upload and download progress on standard error")
+	byID := flag.Bool("id", false, "treat the file argument of get, delete, and rename as a file ID instead of a filename")
+	var metadata metadataFlag
+	flag.Var(&metadata, "metadata", "a key=value pair to store in the metadata of an uploaded file, can be repeated")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *uri == "" {
+		log.Fatal("You must pass a connection string with -uri or set your 'MONGODB_URI' environmental variable.")
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(*uri))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Disconnect(context.TODO())
+
+	// begin custom bucket
+	db := client.Database(*dbName)
+	bucketOpts := options.GridFSBucket().
+		SetName(*bucketName).
+		SetChunkSizeBytes(int32(*chunkSize))
+	bucket, err := gridfs.NewBucket(db, bucketOpts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// end custom bucket
+
+	files := db.Collection(*bucketName + ".files")
+	args := flag.Args()[1:]
+
+	switch command := flag.Arg(0); command {
+	case "put":
+		requireArgs(args, 1, 2)
+		err = put(bucket, args, bson.D(metadata), *progress)
+	case "get":
+		requireArgs(args, 1, 2)
+		err = get(bucket, args, *byID, int32(*revision), *progress)
+	case "list":
+		requireArgs(args, 0, 1)
+		prefix := ""
+		if len(args) == 1 {
+			prefix = args[0]
+		}
+		filter := bson.D{{"filename", bson.D{{"$regex", "^" + regexp.QuoteMeta(prefix)}}}}
+		err = list(bucket, filter)
+	case "search":
+		requireArgs(args, 1, -1)
+		err = search(bucket, args)
+	case "delete":
+		requireArgs(args, 1, 1)
+		err = deleteFiles(bucket, files, args[0], *byID)
+	case "rename":
+		requireArgs(args, 2, 2)
+		err = rename(bucket, files, args[0], args[1], *byID)
+	case "stats":
+		requireArgs(args, 0, 0)
+		err = stats(db, *bucketName)
+	default:
+		log.Fatalf("unknown command %q", command)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func requireArgs(args []string, min, max int) {
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// begin put
+func put(bucket *gridfs.Bucket, args []string, metadata bson.D, progress bool) error {
+	var source io.Reader = os.Stdin
+	var size int64
+	if len(args) == 2 && args[1] != "-" {
+		file, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if info, err := file.Stat(); err == nil {
+			size = info.Size()
+		}
+		source = file
+	}
+
+	uploadOpts := options.GridFSUpload()
+	if len(metadata) > 0 {
+		uploadOpts.SetMetadata(metadata)
+	}
+	reader := &progressReader{r: source, label: "uploaded", total: size, enabled: progress}
+
+	// UploadFromStream reads one chunk at a time, so the size of the input
+	// doesn't matter
+	id, err := bucket.UploadFromStream(args[0], reader, uploadOpts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "added %s (%d bytes) with ID %s\n", args[0], reader.read, id.Hex())
+	return nil
+}
+
+// end put
+
+// begin get
+func get(bucket *gridfs.Bucket, args []string, byID bool, revision int32, progress bool) (err error) {
+	var stream *gridfs.DownloadStream
+	if byID {
+		stream, err = bucket.OpenDownloadStream(parseID(args[0]))
+	} else {
+		nameOpts := options.GridFSName().SetRevision(revision)
+		stream, err = bucket.OpenDownloadStreamByName(args[0], nameOpts)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", args[0], err)
+	}
+	defer stream.Close()
+
+	var destination io.Writer = os.Stdout
+	if len(args) == 2 && args[1] != "-" {
+		file, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		// Close() can report a write that failed after io.Copy() returned
+		defer func() {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		destination = file
+	}
+
+	reader := &progressReader{r: stream, label: "downloaded", total: stream.GetFile().Length, enabled: progress}
+	_, err = io.Copy(destination, reader)
+	return err
+}
+
+// end get
+
+func list(bucket *gridfs.Bucket, filter interface{}) error {
+	opts := options.GridFSFind().SetSort(bson.D{{"filename", 1}, {"uploadDate", 1}})
+	cursor, err := bucket.Find(filter, opts)
+	if err != nil {
+		return err
+	}
+	var found []gridfsFile
+	if err := cursor.All(context.TODO(), &found); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "ID\tFILENAME\tLENGTH\tUPLOADED\tMETADATA")
+	for _, file := range found {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%v\n",
+			formatID(file.ID), file.Name, file.Length, file.UploadDate.Format(time.RFC3339), file.Metadata)
+	}
+	return nil
+}
+
+// begin search
+func search(bucket *gridfs.Bucket, pairs []string) error {
+	filter := bson.D{}
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("search terms must have the form key=value, got %q", pair)
+		}
+		filter = append(filter, bson.E{Key: "metadata." + parts[0], Value: parts[1]})
+	}
+	return list(bucket, filter)
+}
+
+// end search
+
+// resolve returns the ID that the -id flag refers to, or the IDs of every
+// revision of the file with the given name.
+func resolve(files *mongo.Collection, nameOrID string, byID bool) ([]bson.RawValue, error) {
+	if byID {
+		return []bson.RawValue{parseID(nameOrID)}, nil
+	}
+
+	opts := options.Find().SetProjection(bson.D{{"_id", 1}})
+	cursor, err := files.Find(context.TODO(), bson.D{{"filename", nameOrID}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var found []gridfsFile
+	if err := cursor.All(context.TODO(), &found); err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("%s: %v", nameOrID, gridfs.ErrFileNotFound)
+	}
+
+	ids := make([]bson.RawValue, len(found))
+	for i, file := range found {
+		ids[i] = file.ID
+	}
+	return ids, nil
+}
+
+func deleteFiles(bucket *gridfs.Bucket, files *mongo.Collection, nameOrID string, byID bool) error {
+	ids, err := resolve(files, nameOrID, byID)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := bucket.Delete(id); err != nil {
+			return fmt.Errorf("%s: %v", formatID(id), err)
+		}
+		fmt.Fprintf(os.Stderr, "deleted %s\n", formatID(id))
+	}
+	return nil
+}
+
+func rename(bucket *gridfs.Bucket, files *mongo.Collection, nameOrID, newName string, byID bool) error {
+	ids, err := resolve(files, nameOrID, byID)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := bucket.Rename(id, newName); err != nil {
+			return fmt.Errorf("%s: %v", formatID(id), err)
+		}
+		fmt.Fprintf(os.Stderr, "renamed %s to %s\n", formatID(id), newName)
+	}
+	return nil
+}
+
+// begin stats
+func stats(db *mongo.Database, bucketName string) error {
+	groupStage := bson.D{{"$group", bson.D{
+		{"_id", nil},
+		{"files", bson.D{{"$sum", 1}}},
+		{"totalLength", bson.D{{"$sum", "$length"}}},
+		{"largest", bson.D{{"$max", "$length"}}},
+	}}}
+	cursor, err := db.Collection(bucketName+".files").Aggregate(context.TODO(), mongo.Pipeline{groupStage})
+	if err != nil {
+		return err
+	}
+	var results []struct {
+		Files       int64 `bson:"files"`
+		TotalLength int64 `bson:"totalLength"`
+		Largest     int64 `bson:"largest"`
+	}
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		return err
+	}
+
+	chunks, err := db.Collection(bucketName + ".chunks").EstimatedDocumentCount(context.TODO())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("bucket:       %s.%s\n", db.Name(), bucketName)
+	if len(results) == 0 {
+		fmt.Println("files:        0")
+		return nil
+	}
+	fmt.Printf("files:        %d\n", results[0].Files)
+	fmt.Printf("chunks:       %d\n", chunks)
+	fmt.Printf("total length: %d bytes\n", results[0].TotalLength)
+	fmt.Printf("largest file: %d bytes\n", results[0].Largest)
+	return nil
+}
+
+// end stats
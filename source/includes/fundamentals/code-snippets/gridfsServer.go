@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errUnsatisfiableRange = errors.New("unsatisfiable range")
+
+// begin file handler
+// fileStore finds and opens the files that a fileHandler serves.
+type fileStore interface {
+	// FindFile returns the most recent revision of the file with the given
+	// name, or gridfs.ErrFileNotFound
+	FindFile(ctx context.Context, name string) (*gridfs.File, error)
+	OpenFile(file *gridfs.File) (fileStream, error)
+}
+
+// fileStream reads the content of a file. *gridfs.DownloadStream
+// implements it.
+type fileStream interface {
+	io.Reader
+	Skip(skip int64) (int64, error)
+	Close() error
+}
+
+// fileHandler serves the most recent revision of each file in a store at
+// /files/<filename>.
+type fileHandler struct {
+	store fileStore
+}
+
+func (h *fileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/files/")
+	file, err := h.store.FindFile(r.Context(), name)
+	if err == gridfs.ErrFileNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Files in GridFS never change after upload, so the file ID identifies
+	// the content and can serve as a strong entity tag
+	id := fmt.Sprint(file.ID)
+	if oid, ok := file.ID.(primitive.ObjectID); ok {
+		id = oid.Hex()
+	}
+	etag := fmt.Sprintf("%q", id)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", file.UploadDate.UTC().Format(http.TimeFormat))
+	w.Header().Set("Content-Type", contentType(file))
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	start, length := int64(0), file.Length
+	status := http.StatusOK
+	// Ignore the Range header if If-Range names a different version
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && ifRangeMatches(r.Header.Get("If-Range"), etag) {
+		start, length, err = parseRange(rangeHeader, file.Length)
+		if err == errUnsatisfiableRange {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", file.Length))
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if err == nil {
+			status = http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, file.Length))
+		} else {
+			// Serve the whole file if the client sends a range this handler
+			// doesn't support, such as multiple ranges
+			start, length = 0, file.Length
+		}
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+
+	stream, err := h.store.OpenFile(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer stream.Close()
+
+	// Skip discards the chunks before the start of the range
+	if _, err := stream.Skip(start); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(status)
+	// CopyN writes one chunk at a time, so the handler never holds the
+	// whole file in memory
+	if _, err := io.CopyN(w, stream, length); err != nil {
+		log.Printf("error streaming %s: %v", name, err)
+	}
+}
+
+// end file handler
+
+// bucketStore is a fileStore that reads files from a GridFS bucket.
+type bucketStore struct {
+	bucket *gridfs.Bucket
+}
+
+func (s bucketStore) FindFile(ctx context.Context, name string) (*gridfs.File, error) {
+	opts := options.GridFSFind().
+		SetSort(bson.D{{"uploadDate", -1}}).
+		SetLimit(1)
+	cursor, err := s.bucket.Find(bson.D{{"filename", name}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if !cursor.Next(ctx) {
+		if err := cursor.Err(); err != nil {
+			return nil, err
+		}
+		return nil, gridfs.ErrFileNotFound
+	}
+	var file gridfs.File
+	if err := cursor.Decode(&file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (s bucketStore) OpenFile(file *gridfs.File) (fileStream, error) {
+	stream, err := s.bucket.OpenDownloadStream(file.ID)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// memoryStore is a fileStore that holds files in memory, so that
+// checkMemory can check the responses of the handler without a deployment.
+type memoryStore map[string]memoryFile
+
+type memoryFile struct {
+	file    *gridfs.File
+	content []byte
+}
+
+func (s memoryStore) FindFile(ctx context.Context, name string) (*gridfs.File, error) {
+	f, ok := s[name]
+	if !ok {
+		return nil, gridfs.ErrFileNotFound
+	}
+	return f.file, nil
+}
+
+func (s memoryStore) OpenFile(file *gridfs.File) (fileStream, error) {
+	return &memoryStream{bytes.NewReader(s[file.Name].content)}, nil
+}
+
+type memoryStream struct {
+	*bytes.Reader
+}
+
+func (m *memoryStream) Skip(skip int64) (int64, error) {
+	_, err := m.Seek(skip, io.SeekCurrent)
+	return skip, err
+}
+
+func (m *memoryStream) Close() error {
+	return nil
+}
+
+// contentType returns the contentType metadata field of the file, or a
+// type based on the file extension.
+func contentType(file *gridfs.File) string {
+	if file.Metadata != nil {
+		if value, ok := file.Metadata.Lookup("contentType").StringValueOK(); ok {
+			return value
+		}
+	}
+	if value := mime.TypeByExtension(path.Ext(file.Name)); value != "" {
+		return value
+	}
+	return "application/octet-stream"
+}
+
+// etagMatches reports whether an If-None-Match header matches the entity tag.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifRangeMatches reports whether an If-Range header allows a partial
+// response. An empty header always allows one.
+func ifRangeMatches(header, etag string) bool {
+	return header == "" || header == etag
+}
+
+// parseRange parses a Range header with a single byte range and returns the
+// start offset and length of the range.
+func parseRange(header string, size int64) (int64, int64, error) {
+	if !strings.HasPrefix(header, "bytes=") {
+		return 0, 0, fmt.Errorf("unsupported range unit in %q", header)
+	}
+	spec := strings.TrimPrefix(header, "bytes=")
+	if strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("multiple ranges are not supported")
+	}
+	dash := strings.Index(spec, "-")
+	if dash < 0 {
+		return 0, 0, fmt.Errorf("invalid range %q", header)
+	}
+	first, last := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+
+	if first == "" {
+		// A suffix range such as bytes=-500 selects the last 500 bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid range %q", header)
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, errUnsatisfiableRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("invalid range %q", header)
+	}
+	if start >= size {
+		return 0, 0, errUnsatisfiableRange
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, fmt.Errorf("invalid range %q", header)
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, nil
+}
+
+func main() {
+	listen := flag.String("listen", "", "the address to serve files on, such as :8080. If empty, the program checks the handler with sample requests and exits")
+	flag.Parse()
+
+	if *listen == "" {
+		checkMemory()
+	}
+
+	var uri string
+	if uri = os.Getenv("MONGODB_URI"); uri == "" {
+		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/")
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err = client.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+
+	if *listen == "" {
+		checkBucket(client.Database("myDB"))
+		return
+	}
+
+	bucket, err := gridfs.NewBucket(client.Database("myDB"))
+	if err != nil {
+		panic(err)
+	}
+
+	// begin serve files
+	handler := &fileHandler{store: bucketStore{bucket}}
+	mux := http.NewServeMux()
+	mux.Handle("/files/", handler)
+	// end serve files
+
+	log.Printf("Serving GridFS files on %s/files/", *listen)
+	log.Fatal(http.ListenAndServe(*listen, mux))
+}
+
+// sampleContent returns the content of the file that the checks serve.
+func sampleContent() []byte {
+	return []byte(strings.Repeat("0123456789", 100000))
+}
+
+// checkMemory checks the handler against a file in a memoryStore, which
+// doesn't need a deployment.
+func checkMemory() {
+	content := sampleContent()
+	metadata, err := bson.Marshal(bson.D{{"contentType", "text/plain"}})
+	if err != nil {
+		panic(err)
+	}
+	file := &gridfs.File{
+		ID:         primitive.NewObjectID(),
+		Length:     int64(len(content)),
+		ChunkSize:  64 * 1024,
+		UploadDate: time.Now(),
+		Name:       "numbers.txt",
+		Metadata:   metadata,
+	}
+	fmt.Println("In-memory store:")
+	checkHandler(memoryStore{file.Name: {file, content}}, file, content)
+}
+
+// checkBucket uploads the sample file to a GridFS bucket with small chunks,
+// so that the ranges span several chunks, and checks the handler against
+// the bucket. Skip() in the download stream must then move across chunks.
+func checkBucket(db *mongo.Database) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName("serverCheck").SetChunkSizeBytes(4096))
+	if err != nil {
+		panic(err)
+	}
+	if err := bucket.Drop(); err != nil {
+		panic(err)
+	}
+	defer bucket.Drop()
+
+	content := sampleContent()
+	opts := options.GridFSUpload().SetMetadata(bson.D{{"contentType", "text/plain"}})
+	if _, err := bucket.UploadFromStream("numbers.txt", bytes.NewReader(content), opts); err != nil {
+		panic(err)
+	}
+	store := bucketStore{bucket}
+	file, err := store.FindFile(context.TODO(), "numbers.txt")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("GridFS bucket:")
+	checkHandler(store, file, content)
+}
+
+// checkHandler serves the files in store from a local test server, sends
+// sample requests for file, whose content is content, and stops the program
+// if a response is wrong.
+func checkHandler(store fileStore, file *gridfs.File, content []byte) {
+	mux := http.NewServeMux()
+	mux.Handle("/files/", &fileHandler{store: store})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	etag := fmt.Sprintf("%q", file.ID.(primitive.ObjectID).Hex())
+	size := len(content)
+	chunk := int(file.ChunkSize)
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		status  int
+		body    []byte
+		header  map[string]string
+	}{
+		{
+			name:   "whole file",
+			status: http.StatusOK,
+			body:   content,
+			header: map[string]string{"ETag": etag, "Content-Type": "text/plain"},
+		},
+		{
+			name:    "range across a chunk boundary",
+			headers: map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", chunk-6, chunk+9)},
+			status:  http.StatusPartialContent,
+			body:    content[chunk-6 : chunk+10],
+			header:  map[string]string{"Content-Range": fmt.Sprintf("bytes %d-%d/%d", chunk-6, chunk+9, size)},
+		},
+		{
+			name:    "range across several chunks",
+			headers: map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", 2*chunk+1, 5*chunk+2)},
+			status:  http.StatusPartialContent,
+			body:    content[2*chunk+1 : 5*chunk+3],
+		},
+		{
+			name:    "open-ended range",
+			headers: map[string]string{"Range": fmt.Sprintf("bytes=%d-", size-10)},
+			status:  http.StatusPartialContent,
+			body:    content[size-10:],
+		},
+		{
+			name:    "suffix range",
+			headers: map[string]string{"Range": "bytes=-5"},
+			status:  http.StatusPartialContent,
+			body:    content[size-5:],
+		},
+		{
+			name:    "unsatisfiable range",
+			headers: map[string]string{"Range": fmt.Sprintf("bytes=%d-", size)},
+			status:  http.StatusRequestedRangeNotSatisfiable,
+			header:  map[string]string{"Content-Range": fmt.Sprintf("bytes */%d", size)},
+		},
+		{
+			name:    "multiple ranges",
+			headers: map[string]string{"Range": "bytes=0-9,20-29"},
+			status:  http.StatusOK,
+			body:    content,
+		},
+		{
+			name:    "matching If-None-Match",
+			headers: map[string]string{"If-None-Match": etag},
+			status:  http.StatusNotModified,
+			body:    []byte{},
+		},
+		{
+			name:    "stale If-Range",
+			headers: map[string]string{"Range": "bytes=0-9", "If-Range": `"stale"`},
+			status:  http.StatusOK,
+			body:    content,
+		},
+		{
+			name:    "matching If-Range",
+			headers: map[string]string{"Range": "bytes=0-9", "If-Range": etag},
+			status:  http.StatusPartialContent,
+			body:    content[:10],
+		},
+		{
+			name:   "HEAD",
+			method: http.MethodHead,
+			status: http.StatusOK,
+			body:   []byte{},
+			header: map[string]string{"Content-Length": strconv.Itoa(size)},
+		},
+		{
+			name:   "POST",
+			method: http.MethodPost,
+			status: http.StatusMethodNotAllowed,
+			header: map[string]string{"Allow": "GET, HEAD"},
+		},
+		{
+			name:   "missing file",
+			path:   "/files/missing.txt",
+			status: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		method, path := test.method, test.path
+		if method == "" {
+			method = http.MethodGet
+		}
+		if path == "" {
+			path = "/files/" + file.Name
+		}
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		if err != nil {
+			panic(err)
+		}
+		for key, value := range test.headers {
+			req.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			panic(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			panic(err)
+		}
+
+		if resp.StatusCode != test.status {
+			log.Fatalf("%s: expected status %d, got %d", test.name, test.status, resp.StatusCode)
+		}
+		if test.body != nil && !bytes.Equal(body, test.body) {
+			log.Fatalf("%s: expected a %d byte body, got %d bytes", test.name, len(test.body), len(body))
+		}
+		for key, value := range test.header {
+			if got := resp.Header.Get(key); got != value {
+				log.Fatalf("%s: expected %s header %q, got %q", test.name, key, value, got)
+			}
+		}
+		fmt.Printf("%s: %s\n", test.name, resp.Status)
+	}
+}
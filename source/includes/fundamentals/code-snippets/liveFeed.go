@@ -0,0 +1,612 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// begin filter
+// allowedParams maps the query parameters that clients can filter on to
+// the change event fields they match. Any other parameter is rejected, so
+// clients can't filter on fields you don't index or don't want to expose.
+var allowedParams = map[string]string{
+	"operationType": "operationType",
+	"borough":       "fullDocument.borough",
+	"cuisine":       "fullDocument.cuisine",
+	"name":          "fullDocument.name",
+}
+
+// feedFilter maps change event fields to the values they can have. An
+// event matches if every field has one of its values.
+type feedFilter map[string][]string
+
+func parseFilter(query url.Values) (feedFilter, error) {
+	filter := feedFilter{}
+	for param, values := range query {
+		field, ok := allowedParams[param]
+		if !ok {
+			return nil, fmt.Errorf("can't filter on %q", param)
+		}
+		filter[field] = values
+	}
+	return filter, nil
+}
+
+// matches reports whether an event matches the filter.
+func (f feedFilter) matches(event bson.Raw) bool {
+	for field, values := range f {
+		value, ok := event.Lookup(strings.Split(field, ".")...).StringValueOK()
+		if !ok || !contains(values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// pipeline returns a change stream pipeline that matches the same events as
+// the filter.
+func (f feedFilter) pipeline() mongo.Pipeline {
+	match := bson.D{}
+	for field, values := range f {
+		match = append(match, bson.E{field, bson.D{{"$in", values}}})
+	}
+	// Sort the fields so that equal filters produce equal pipelines
+	sort.Slice(match, func(i, j int) bool { return match[i].Key < match[j].Key })
+	return mongo.Pipeline{{{"$match", match}}}
+}
+
+// end filter
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// feedEvent is a change event formatted for the feed. Its ID is the _data
+// field of the event's resume token.
+type feedEvent struct {
+	id            string
+	operationType string
+	data          []byte
+	raw           bson.Raw
+}
+
+func newFeedEvent(cs *mongo.ChangeStream) (feedEvent, error) {
+	data, err := bson.MarshalExtJSON(cs.Current, false, false)
+	if err != nil {
+		return feedEvent{}, err
+	}
+	raw := append(bson.Raw(nil), cs.Current...)
+	return feedEvent{
+		id:            cs.Current.Lookup("_id", "_data").StringValue(),
+		operationType: raw.Lookup("operationType").StringValue(),
+		data:          data,
+		raw:           raw,
+	}, nil
+}
+
+// tokenData returns the _data field of a resume token. Tokens from the same
+// deployment sort in the order of their events when you compare their _data
+// strings, including the tokens of empty batches.
+func tokenData(token bson.Raw) string {
+	data, _ := token.Lookup("_data").StringValueOK()
+	return data
+}
+
+// subscriber receives the events after the position in after that match its
+// filter.
+type subscriber struct {
+	filter feedFilter
+	after  string
+	events chan feedEvent
+}
+
+// begin hub
+// hub reads one change stream and sends each event to every subscriber
+// whose filter matches it. It keeps the most recent events so that a client
+// that reconnects with a Last-Event-ID header receives the events it missed.
+type hub struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	recent      []feedEvent
+	keep        int
+	// start is the position before the oldest recent event, so the hub
+	// holds every event after start
+	start string
+}
+
+func newHub(keep int) *hub {
+	return &hub{subscribers: make(map[*subscriber]struct{}), keep: keep}
+}
+
+// run reads cs, and the change streams that replace it, until ctx is
+// canceled. A change stream ends without an error after an invalidate
+// event, for example when the collection is dropped or renamed, so run
+// opens a new one that starts after the invalidate event. If a change
+// stream returns an error, run disconnects every client and returns it.
+func (h *hub) run(ctx context.Context, coll *mongo.Collection, cs *mongo.ChangeStream) error {
+	h.advance(tokenData(cs.ResumeToken()))
+	for {
+		err := h.read(ctx, cs)
+		token := cs.ResumeToken()
+		cs.Close(context.Background())
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			h.closeAll()
+			return err
+		}
+
+		log.Printf("the change stream ended, opening a new one after %s", tokenData(token))
+		opts := options.ChangeStream().
+			SetFullDocument(options.UpdateLookup).
+			SetStartAfter(token)
+		if cs, err = coll.Watch(ctx, mongo.Pipeline{}, opts); err != nil {
+			h.closeAll()
+			return fmt.Errorf("can't reopen the change stream: %v", err)
+		}
+	}
+}
+
+// read sends the events of cs to the subscribers until cs ends or returns
+// an error.
+func (h *hub) read(ctx context.Context, cs *mongo.ChangeStream) error {
+	for {
+		if !cs.TryNext(ctx) {
+			if err := cs.Err(); err != nil {
+				return err
+			}
+			// The server closes the cursor after an invalidate event
+			if cs.ID() == 0 {
+				return nil
+			}
+			// An empty batch moves the resume token past the events that
+			// the server has checked
+			h.advance(tokenData(cs.ResumeToken()))
+			continue
+		}
+		event, err := newFeedEvent(cs)
+		if err != nil {
+			return err
+		}
+		h.publish(event)
+	}
+}
+
+func (h *hub) publish(event feedEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.recent = append(h.recent, event)
+	if len(h.recent) > h.keep {
+		h.start = h.recent[0].id
+		h.recent = h.recent[1:]
+	}
+	for sub := range h.subscribers {
+		if event.id <= sub.after || !sub.filter.matches(event.raw) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			// The client reads too slowly. Disconnect it instead of
+			// slowing down every other client. It reconnects with
+			// Last-Event-ID and receives the events it missed.
+			delete(h.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// advance records the position of the change stream when the hub doesn't
+// know the position before its oldest event yet.
+func (h *hub) advance(position string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.start == "" && len(h.recent) == 0 {
+		h.start = position
+	}
+}
+
+// subscribe registers a subscriber. If after isn't empty, subscribe also
+// returns the recent events after that position that match the filter. It
+// returns false if the position is too old for the recent events to cover.
+func (h *hub) subscribe(filter feedFilter, after string) (*subscriber, []feedEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if after != "" {
+		covered := h.start != "" && after >= h.start
+		if len(h.recent) > 0 && after >= h.recent[0].id {
+			covered = true
+		}
+		if !covered {
+			return nil, nil, false
+		}
+	}
+
+	// Registering the subscriber while holding the lock guarantees that no
+	// event falls between the missed events and the live ones
+	sub := &subscriber{filter: filter, after: after, events: make(chan feedEvent, 64)}
+	h.subscribers[sub] = struct{}{}
+
+	var missed []feedEvent
+	for _, event := range h.recent {
+		if event.id > after && filter.matches(event.raw) {
+			missed = append(missed, event)
+		}
+	}
+	return sub, missed, true
+}
+
+func (h *hub) unsubscribe(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// closeAll disconnects every client.
+func (h *hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// end hub
+
+// begin feed handler
+// feedHandler streams change events to clients as Server-Sent Events.
+type feedHandler struct {
+	hub  *hub
+	coll *mongo.Collection
+}
+
+func (h *feedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if _, err := hex.DecodeString(lastID); err != nil {
+		http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+		return
+	}
+
+	sub, missed, ok := h.hub.subscribe(filter, lastID)
+	if !ok {
+		// The client missed more events than the hub keeps. Resume a
+		// change stream of its own from the client's last event.
+		h.serveResumed(w, r, flusher, filter, lastID)
+		return
+	}
+	startStream(w)
+	h.serveSubscriber(w, r, flusher, sub, missed)
+}
+
+// serveSubscriber sends the missed events and then the live events of sub
+// until the client disconnects.
+func (h *feedHandler) serveSubscriber(w http.ResponseWriter, r *http.Request, flusher http.Flusher, sub *subscriber, missed []feedEvent) {
+	defer h.hub.unsubscribe(sub)
+	for _, event := range missed {
+		writeEvent(w, event)
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case event, ok := <-sub.events:
+			if !ok {
+				return
+			}
+			writeEvent(w, event)
+			flusher.Flush()
+		case <-keepalive.C:
+			// A comment line keeps proxies from closing an idle connection
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// end feed handler
+
+// begin serve resumed
+// serveResumed sends the events after lastID from a change stream of its
+// own until the change stream reaches a position that the hub's recent
+// events cover. Then it closes the change stream and moves the client onto
+// the hub, so that each client uses a server cursor only while it catches
+// up.
+func (h *feedHandler) serveResumed(w http.ResponseWriter, r *http.Request, flusher http.Flusher, filter feedFilter, lastID string) {
+	opts := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetResumeAfter(bson.D{{"_data", lastID}})
+	cs, err := h.coll.Watch(r.Context(), filter.pipeline(), opts)
+	if err != nil {
+		// The event is no longer in the oplog, so the client can't receive
+		// every event it missed
+		http.Error(w, "can't resume from Last-Event-ID: "+err.Error(), http.StatusGone)
+		return
+	}
+	defer cs.Close(context.Background())
+
+	startStream(w)
+	flusher.Flush()
+	for {
+		if cs.TryNext(r.Context()) {
+			event, err := newFeedEvent(cs)
+			if err != nil {
+				return
+			}
+			writeEvent(w, event)
+			flusher.Flush()
+		} else if cs.Err() != nil || cs.ID() == 0 {
+			return
+		}
+
+		// The resume token is the position of the last event, or of the
+		// last empty batch, so every event after it is still to be sent
+		sub, missed, ok := h.hub.subscribe(filter, tokenData(cs.ResumeToken()))
+		if ok {
+			cs.Close(context.Background())
+			h.serveSubscriber(w, r, flusher, sub, missed)
+			return
+		}
+	}
+}
+
+// end serve resumed
+
+func startStream(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+}
+
+func writeEvent(w http.ResponseWriter, event feedEvent) {
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.id, event.operationType, event.data)
+}
+
+func main() {
+	listen := flag.String("listen", "", "the address to serve the feed on, such as :8080. If empty, the program checks the handler with sample clients and exits")
+	keep := flag.Int("keep", 1000, "the number of recent events to keep for clients that reconnect")
+	flag.Parse()
+
+	// Change streams require a replica set or sharded cluster
+	var uri string
+	if uri = os.Getenv("MONGODB_URI"); uri == "" {
+		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/")
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err = client.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+
+	coll := client.Database("sample_restaurants").Collection("restaurants")
+	if *listen == "" {
+		// Keep few events, so that the check also covers clients that
+		// resume from an older event
+		*keep = 3
+	}
+
+	// begin start feed
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	cs, err := coll.Watch(context.TODO(), mongo.Pipeline{}, opts)
+	if err != nil {
+		panic(err)
+	}
+
+	// run closes cs, and returns an error only after it disconnects every
+	// client
+	h := newHub(*keep)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := h.run(ctx, coll, cs); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/events", &feedHandler{hub: h, coll: coll})
+	// end start feed
+
+	if *listen != "" {
+		log.Printf("Serving change events on %s/events", *listen)
+		log.Fatal(http.ListenAndServe(*listen, mux))
+	}
+
+	checkFeed(coll, mux)
+}
+
+// sseEvent is an event that a test client received.
+type sseEvent struct {
+	id, event, name string
+}
+
+// sseClient reads the events of one Server-Sent Events response.
+type sseClient struct {
+	resp   *http.Response
+	events chan sseEvent
+}
+
+func connect(url, lastID string) (*sseClient, int) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		panic(err)
+	}
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		panic(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, resp.StatusCode
+	}
+
+	c := &sseClient{resp: resp, events: make(chan sseEvent, 100)}
+	go func() {
+		defer close(c.events)
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(nil, 1024*1024)
+		var current sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if current.id != "" {
+					c.events <- current
+				}
+				current = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				current.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				current.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				var event struct {
+					FullDocument struct {
+						Name string `bson:"name"`
+					} `bson:"fullDocument"`
+				}
+				if err := bson.UnmarshalExtJSON([]byte(strings.TrimPrefix(line, "data: ")), false, &event); err != nil {
+					panic(err)
+				}
+				current.name = event.FullDocument.Name
+			}
+		}
+	}()
+	return c, http.StatusOK
+}
+
+// expect reads events until it has received the given restaurant names, and
+// returns the ID of the last event.
+func (c *sseClient) expect(label string, names ...string) string {
+	var got []string
+	var lastID string
+	timeout := time.After(10 * time.Second)
+	for len(got) < len(names) {
+		select {
+		case event, ok := <-c.events:
+			if !ok {
+				log.Fatalf("%s: the stream ended after %q", label, got)
+			}
+			got = append(got, event.name)
+			lastID = event.id
+		case <-timeout:
+			log.Fatalf("%s: timed out after receiving %q, want %q", label, got, names)
+		}
+	}
+	if strings.Join(got, ",") != strings.Join(names, ",") {
+		log.Fatalf("%s: received %q, want %q", label, got, names)
+	}
+	fmt.Printf("%s: received %q\n", label, got)
+	return lastID
+}
+
+func (c *sseClient) close() {
+	c.resp.Body.Close()
+}
+
+// checkFeed connects test clients to the handler through an httptest
+// server, inserts documents, and stops the program if a client receives
+// the wrong events.
+func checkFeed(coll *mongo.Collection, handler http.Handler) {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	url := server.URL + "/events"
+
+	if _, status := connect(url+"?grade=A", ""); status != http.StatusBadRequest {
+		log.Fatalf("filtering on a field that isn't allowed returned %d, want 400", status)
+	}
+
+	insert := func(names ...string) {
+		for _, name := range names {
+			cuisine := "Korean"
+			if strings.HasPrefix(name, "Thai") {
+				cuisine = "Thai"
+			}
+			doc := bson.D{{"name", name}, {"cuisine", cuisine}, {"borough", "Queens"}}
+			if _, err := coll.InsertOne(context.TODO(), doc); err != nil {
+				panic(err)
+			}
+		}
+	}
+	defer coll.DeleteMany(context.TODO(), bson.D{{"name", bson.D{{"$regex", "^(Korean|Thai) Test"}}}})
+
+	all, _ := connect(url, "")
+	defer all.close()
+	korean, _ := connect(url+"?cuisine=Korean&operationType=insert", "")
+
+	insert("Korean Test 1", "Thai Test 1", "Korean Test 2")
+	all.expect("all events", "Korean Test 1", "Thai Test 1", "Korean Test 2")
+	lastID := korean.expect("Korean filter", "Korean Test 1", "Korean Test 2")
+
+	// Disconnect, miss two events, and reconnect with Last-Event-ID. The
+	// hub still keeps the missed events.
+	korean.close()
+	insert("Korean Test 3", "Korean Test 4")
+	all.expect("all events", "Korean Test 3", "Korean Test 4")
+	korean, _ = connect(url+"?cuisine=Korean&operationType=insert", lastID)
+	lastID = korean.expect("reconnected from recent events", "Korean Test 3", "Korean Test 4")
+
+	// Disconnect and miss more events than the hub keeps. The handler
+	// resumes a change stream from Last-Event-ID instead.
+	korean.close()
+	insert("Korean Test 5", "Thai Test 2", "Thai Test 3", "Korean Test 6", "Thai Test 4")
+	all.expect("all events", "Korean Test 5", "Thai Test 2", "Thai Test 3", "Korean Test 6", "Thai Test 4")
+	korean, _ = connect(url+"?cuisine=Korean&operationType=insert", lastID)
+	defer korean.close()
+	korean.expect("reconnected from a resumed change stream", "Korean Test 5", "Korean Test 6")
+
+	// Once the resumed change stream catches up, the client moves onto the
+	// hub and receives live events from the shared change stream
+	insert("Thai Test 5", "Korean Test 7")
+	all.expect("all events", "Thai Test 5", "Korean Test 7")
+	korean.expect("moved onto the hub", "Korean Test 7")
+
+	fmt.Println("\nEvery client received the events that match its filter")
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mirrorState is the document in which the mirror saves its position, so
+// that a restarted mirror continues tailing instead of copying again.
+type mirrorState struct {
+	Namespace    string              `bson:"_id"`
+	SnapshotTime primitive.Timestamp `bson:"snapshotTime"`
+	ResumeToken  bson.Raw            `bson:"resumeToken,omitempty"`
+	UpdatedAt    time.Time           `bson:"updatedAt"`
+}
+
+// changeEvent contains the fields of a change event that the mirror uses.
+type changeEvent struct {
+	OperationType string              `bson:"operationType"`
+	ClusterTime   primitive.Timestamp `bson:"clusterTime"`
+	DocumentKey   bson.Raw            `bson:"documentKey"`
+	FullDocument  bson.Raw            `bson:"fullDocument"`
+}
+
+type mirror struct {
+	source    *mongo.Collection
+	target    *mongo.Collection
+	state     *mongo.Collection
+	namespace string
+	batchSize int
+	interval  time.Duration
+}
+
+func main() {
+	sourceURI := flag.String("source", "", "the connection string of the source deployment, which must be a replica set or sharded cluster")
+	targetURI := flag.String("target", "", "the connection string of the target deployment")
+	dbName := flag.String("db", "test", "the database of the collection to mirror")
+	collName := flag.String("coll", "", "the collection to mirror")
+	stateDB := flag.String("state-db", "mirror", "the database on the target in which to save the mirror's position")
+	batchSize := flag.Int("batch-size", 1000, "the maximum number of writes in each bulk write")
+	interval := flag.Duration("report-interval", 5*time.Second, "how often to report the replication lag")
+	untilSynced := flag.Bool("until-synced", false, "stop when the target has caught up, then compare both collections")
+	restart := flag.Bool("restart", false, "discard the saved position and copy the collection again")
+	flag.Parse()
+
+	if *sourceURI == "" || *targetURI == "" || *collName == "" {
+		log.Fatal("-source, -target, and -coll are required")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	sourceClient, err := mongo.Connect(ctx, options.Client().ApplyURI(*sourceURI))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer sourceClient.Disconnect(context.TODO())
+	targetClient, err := mongo.Connect(ctx, options.Client().ApplyURI(*targetURI))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer targetClient.Disconnect(context.TODO())
+
+	m := &mirror{
+		source:    sourceClient.Database(*dbName).Collection(*collName),
+		target:    targetClient.Database(*dbName).Collection(*collName),
+		state:     targetClient.Database(*stateDB).Collection("state"),
+		namespace: *dbName + "." + *collName,
+		batchSize: *batchSize,
+		interval:  *interval,
+	}
+
+	var state mirrorState
+	err = m.state.FindOne(ctx, bson.D{{"_id", m.namespace}}).Decode(&state)
+	switch {
+	case err != nil && err != mongo.ErrNoDocuments:
+		log.Fatal(err)
+	case err == mongo.ErrNoDocuments || *restart:
+		if state, err = m.snapshot(ctx); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Printf("resuming %s from the saved position", m.namespace)
+	}
+
+	if err := m.tail(ctx, state, *untilSynced); err != nil {
+		log.Fatal(err)
+	}
+	if *untilSynced {
+		if err := m.compare(ctx); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s is identical on the source and the target", m.namespace)
+	}
+}
+
+// begin snapshot
+// snapshot records the current operation time of the source and then
+// copies every document to the target. Writes that happen during the copy
+// are applied again when tail starts from the recorded time.
+func (m *mirror) snapshot(ctx context.Context) (mirrorState, error) {
+	// Remove the saved position first, so that a snapshot that stops partway
+	// starts again on the next run instead of tailing an incomplete copy.
+	// Then clear the target, because the copy only upserts the documents
+	// that the source still contains.
+	if _, err := m.state.DeleteOne(ctx, bson.D{{"_id", m.namespace}}); err != nil {
+		return mirrorState{}, err
+	}
+	cleared, err := m.target.DeleteMany(ctx, bson.D{})
+	if err != nil {
+		return mirrorState{}, err
+	}
+	if cleared.DeletedCount > 0 {
+		log.Printf("deleted %d documents from the target of %s", cleared.DeletedCount, m.namespace)
+	}
+
+	// Every command response from a replica set includes the operation
+	// time of the latest write that the command can see
+	var ping struct {
+		OperationTime primitive.Timestamp `bson:"operationTime"`
+	}
+	err = m.source.Database().RunCommand(ctx, bson.D{{"ping", 1}}).Decode(&ping)
+	if err != nil {
+		return mirrorState{}, err
+	}
+	if ping.OperationTime.IsZero() {
+		return mirrorState{}, fmt.Errorf("the source didn't return an operation time, it must be a replica set or sharded cluster")
+	}
+
+	cursor, err := m.source.Find(ctx, bson.D{}, options.Find().SetBatchSize(int32(m.batchSize)))
+	if err != nil {
+		return mirrorState{}, err
+	}
+	defer cursor.Close(ctx)
+
+	var models []mongo.WriteModel
+	copied := 0
+	for cursor.Next(ctx) {
+		doc := append(bson.Raw(nil), cursor.Current...)
+		// Replacing with upsert makes the copy idempotent, so a copy that
+		// stopped partway can run again over the same documents
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.D{{"_id", doc.Lookup("_id")}}).
+			SetReplacement(doc).
+			SetUpsert(true))
+		if len(models) == m.batchSize {
+			if err := m.write(ctx, models, false); err != nil {
+				return mirrorState{}, err
+			}
+			copied += len(models)
+			models = models[:0]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return mirrorState{}, err
+	}
+	if len(models) > 0 {
+		if err := m.write(ctx, models, false); err != nil {
+			return mirrorState{}, err
+		}
+		copied += len(models)
+	}
+	log.Printf("copied %d documents of %s", copied, m.namespace)
+
+	state := mirrorState{Namespace: m.namespace, SnapshotTime: ping.OperationTime}
+	return state, m.save(ctx, state)
+}
+
+// end snapshot
+
+// begin apply
+// apply converts change events into idempotent writes. Applying an event
+// twice, or applying an event to a document that the snapshot already
+// copied in its changed form, leaves the target in the same state.
+func (m *mirror) apply(ctx context.Context, events []changeEvent) error {
+	var models []mongo.WriteModel
+	for _, event := range events {
+		switch event.OperationType {
+		case "insert", "replace", "update":
+			// For update events, UpdateLookup returns the current version
+			// of the document. If the document no longer exists, a later
+			// delete event removes it from the target.
+			if event.FullDocument == nil {
+				continue
+			}
+			models = append(models, mongo.NewReplaceOneModel().
+				SetFilter(event.DocumentKey).
+				SetReplacement(event.FullDocument).
+				SetUpsert(true))
+		case "delete":
+			models = append(models, mongo.NewDeleteOneModel().SetFilter(event.DocumentKey))
+		default:
+			return fmt.Errorf("can't mirror a %s event, restart the mirror with -restart", event.OperationType)
+		}
+	}
+	if len(models) == 0 {
+		return nil
+	}
+	// The writes must run in order, because several events can change the
+	// same document
+	return m.write(ctx, models, true)
+}
+
+// end apply
+
+func (m *mirror) write(ctx context.Context, models []mongo.WriteModel, ordered bool) error {
+	_, err := m.target.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(ordered))
+	return err
+}
+
+func (m *mirror) save(ctx context.Context, state mirrorState) error {
+	state.UpdatedAt = time.Now()
+	opts := options.Replace().SetUpsert(true)
+	_, err := m.state.ReplaceOne(ctx, bson.D{{"_id", state.Namespace}}, state, opts)
+	return err
+}
+
+// begin tail
+// tail applies the changes made on the source after the snapshot time or
+// the saved resume token, and reports how far the target lags behind.
+func (m *mirror) tail(ctx context.Context, state mirrorState, untilSynced bool) error {
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if state.ResumeToken != nil {
+		opts.SetResumeAfter(state.ResumeToken)
+	} else {
+		opts.SetStartAtOperationTime(&state.SnapshotTime)
+	}
+	cs, err := m.source.Watch(ctx, mongo.Pipeline{}, opts)
+	if err != nil {
+		return err
+	}
+	defer cs.Close(context.TODO())
+
+	var applied int
+	var lastApplied primitive.Timestamp
+	lastReport := time.Now()
+	for {
+		var events []changeEvent
+		for len(events) < m.batchSize && cs.TryNext(ctx) {
+			var event changeEvent
+			if err := cs.Decode(&event); err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+		if err := cs.Err(); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if err := m.apply(ctx, events); err != nil {
+			return err
+		}
+		if len(events) > 0 {
+			applied += len(events)
+			lastApplied = events[len(events)-1].ClusterTime
+		}
+		// Save the position only when it moved, so an idle source doesn't
+		// cause a write to the target every poll
+		if token := cs.ResumeToken(); !bytes.Equal(token, state.ResumeToken) {
+			state.ResumeToken = token
+			if err := m.save(ctx, state); err != nil {
+				return err
+			}
+		}
+
+		// An empty batch means the target has applied every change that
+		// the source has made so far
+		caughtUp := len(events) == 0
+		if time.Since(lastReport) >= m.interval || (caughtUp && untilSynced) {
+			lastReport = time.Now()
+			lag := time.Duration(0)
+			if !caughtUp && !lastApplied.IsZero() {
+				lag = time.Since(time.Unix(int64(lastApplied.T), 0)).Truncate(time.Second)
+			}
+			log.Printf("applied %d changes, lag %s", applied, lag)
+		}
+		if caughtUp && untilSynced {
+			return nil
+		}
+		if caughtUp {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+}
+
+// end tail
+
+// compare reads both collections in _id order and reports the first
+// document that differs.
+func (m *mirror) compare(ctx context.Context) error {
+	opts := options.Find().SetSort(bson.D{{"_id", 1}})
+	sourceCursor, err := m.source.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return err
+	}
+	defer sourceCursor.Close(ctx)
+	targetCursor, err := m.target.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return err
+	}
+	defer targetCursor.Close(ctx)
+
+	compared := 0
+	for {
+		sourceNext, targetNext := sourceCursor.Next(ctx), targetCursor.Next(ctx)
+		switch {
+		case !sourceNext && !targetNext:
+			if err := sourceCursor.Err(); err != nil {
+				return err
+			}
+			log.Printf("compared %d documents", compared)
+			return targetCursor.Err()
+		case !targetNext:
+			return fmt.Errorf("the target is missing the document with _id %v", sourceCursor.Current.Lookup("_id"))
+		case !sourceNext:
+			return fmt.Errorf("the target has an extra document with _id %v", targetCursor.Current.Lookup("_id"))
+		case !bytes.Equal(sourceCursor.Current, targetCursor.Current):
+			return fmt.Errorf("the documents differ:\nsource: %v\ntarget: %v", sourceCursor.Current, targetCursor.Current)
+		}
+		compared++
+	}
+}
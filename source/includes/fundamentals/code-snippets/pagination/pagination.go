@@ -0,0 +1,280 @@
+// Package pagination pages through query results with range filters on the
+// sort keys, which is also called keyset pagination.
+//
+// SetSkip() pages through results by counting documents from the start of
+// the results, so the server examines every skipped document and each page
+// takes longer than the one before it. A Paginator instead starts each page
+// after the last document of the previous page. It stores the sort keys and
+// the values of that document in an opaque continuation token, and builds a
+// filter that matches only the documents that sort after those values.
+// With an index on the sort keys, the server examines about the same number
+// of index keys for every page.
+//
+// The Paginator adds _id to the sort keys when they don't contain it, so
+// that the order is unique. For the results to stay consistent, every
+// document must contain each sort key, the values must not be arrays or
+// null, and all values of a key must have the same BSON type.
+package pagination
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidToken means that a token wasn't created by a Paginator with the
+// same sort keys.
+var ErrInvalidToken = errors.New("pagination: invalid token")
+
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+// Paginator pages through results in the order of its sort keys.
+type Paginator struct {
+	keys  []sortKey
+	limit int64
+}
+
+// Page is one page of results and the tokens of the pages around it.
+type Page struct {
+	Documents []bson.Raw
+	// Next is empty on the last page, and Prev is empty on the first page
+	Next string
+	Prev string
+}
+
+// token is the content of a continuation token. Before is true for a token
+// that reads the page before the document, and Keys is the sort document of
+// the Paginator that created the token.
+type token struct {
+	Before bool            `bson:"b,omitempty"`
+	Keys   bson.D          `bson:"k"`
+	Values []bson.RawValue `bson:"v"`
+}
+
+// New returns a Paginator that returns pages of limit documents sorted by
+// sort, such as bson.D{{"enrollment", -1}, {"title", 1}}. Each sort value
+// must be 1 or -1.
+func New(sort bson.D, limit int64) (*Paginator, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("pagination: the limit must be positive, not %d", limit)
+	}
+	p := &Paginator{limit: limit}
+	hasID := false
+	for _, e := range sort {
+		if e.Key == "" || strings.HasPrefix(e.Key, "$") {
+			return nil, fmt.Errorf("pagination: invalid sort key %q", e.Key)
+		}
+		var desc bool
+		switch e.Value {
+		case 1, int32(1), int64(1), 1.0:
+		case -1, int32(-1), int64(-1), -1.0:
+			desc = true
+		default:
+			return nil, fmt.Errorf("pagination: the direction of %q must be 1 or -1, not %v", e.Key, e.Value)
+		}
+		p.keys = append(p.keys, sortKey{e.Key, desc})
+		hasID = hasID || e.Key == "_id"
+	}
+	if !hasID {
+		p.keys = append(p.keys, sortKey{"_id", false})
+	}
+	return p, nil
+}
+
+// Sort returns the sort document of the pages, including _id.
+func (p *Paginator) Sort() bson.D {
+	return p.sort(false)
+}
+
+// sort returns the sort document, which is reversed to read backward.
+func (p *Paginator) sort(reverse bool) bson.D {
+	sort := make(bson.D, len(p.keys))
+	for i, k := range p.keys {
+		direction := 1
+		if k.desc != reverse {
+			direction = -1
+		}
+		sort[i] = bson.E{k.field, direction}
+	}
+	return sort
+}
+
+// After returns a token for the page that starts after doc.
+func (p *Paginator) After(doc bson.Raw) (string, error) {
+	return p.token(doc, false)
+}
+
+// Before returns a token for the page that ends before doc.
+func (p *Paginator) Before(doc bson.Raw) (string, error) {
+	return p.token(doc, true)
+}
+
+func (p *Paginator) token(doc bson.Raw, before bool) (string, error) {
+	t := token{Before: before, Keys: p.Sort()}
+	for _, k := range p.keys {
+		v, err := doc.LookupErr(strings.Split(k.field, ".")...)
+		if err != nil {
+			return "", fmt.Errorf("pagination: the document has no %q field", k.field)
+		}
+		switch v.Type {
+		case bsontype.Array, bsontype.Null, bsontype.Undefined:
+			return "", fmt.Errorf("pagination: %q is %s, which can't be a page boundary", k.field, v.Type)
+		}
+		t.Values = append(t.Values, v)
+	}
+	b, err := bson.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (p *Paginator) parseToken(s string) (token, error) {
+	var t token
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return t, ErrInvalidToken
+	}
+	if err := bson.Unmarshal(b, &t); err != nil || !p.sameKeys(t.Keys) || len(t.Values) != len(p.keys) {
+		return t, ErrInvalidToken
+	}
+	return t, nil
+}
+
+// sameKeys reports whether keys, the sort document of a token, has the
+// same fields and directions as the sort keys of p.
+func (p *Paginator) sameKeys(keys bson.D) bool {
+	if len(keys) != len(p.keys) {
+		return false
+	}
+	for i, k := range p.keys {
+		direction := int32(1)
+		if k.desc {
+			direction = -1
+		}
+		if keys[i].Key != k.field || keys[i].Value != direction {
+			return false
+		}
+	}
+	return true
+}
+
+// Query returns the filter and sort document that read the page of tok.
+// The filter combines filter with the range of tok. An empty token reads
+// the first page.
+func (p *Paginator) Query(filter interface{}, tok string) (interface{}, bson.D, error) {
+	query, t, err := p.query(filter, tok)
+	if err != nil {
+		return nil, nil, err
+	}
+	return query, p.sort(t.Before), nil
+}
+
+func (p *Paginator) query(filter interface{}, tok string) (interface{}, token, error) {
+	if filter == nil {
+		filter = bson.D{}
+	}
+	if tok == "" {
+		return filter, token{}, nil
+	}
+	t, err := p.parseToken(tok)
+	if err != nil {
+		return nil, t, err
+	}
+	r := p.rangeFilter(t)
+	if d, ok := filter.(bson.D); ok && len(d) == 0 {
+		return r, t, nil
+	}
+	return bson.D{{"$and", bson.A{filter, r}}}, t, nil
+}
+
+// rangeFilter matches the documents that sort after the values of t, or
+// before them if t.Before is set. For the sort keys a, b, and _id, the
+// filter after the values (x, y, z) is:
+//
+//	a > x OR (a = x AND b > y) OR (a = x AND b = y AND _id > z)
+//
+// where > becomes < for a descending key or to read backward. The
+// equalities use $eq, which compares a value from the token literally even
+// if the value is a document such as {"$ne": null} or a regular expression.
+func (p *Paginator) rangeFilter(t token) bson.D {
+	var clauses bson.A
+	for i, k := range p.keys {
+		clause := bson.D{}
+		for j := 0; j < i; j++ {
+			clause = append(clause, bson.E{p.keys[j].field, bson.D{{"$eq", t.Values[j]}}})
+		}
+		op := "$gt"
+		if k.desc != t.Before {
+			op = "$lt"
+		}
+		clauses = append(clauses, append(clause, bson.E{k.field, bson.D{{op, t.Values[i]}}}))
+	}
+	if len(clauses) == 1 {
+		return clauses[0].(bson.D)
+	}
+	return bson.D{{"$or", clauses}}
+}
+
+// Find returns the page of tok from the documents in coll that match
+// filter. An empty token reads the first page, and ErrInvalidToken means
+// that tok isn't a token from this Paginator.
+func (p *Paginator) Find(ctx context.Context, coll *mongo.Collection, filter interface{}, tok string) (*Page, error) {
+	query, t, err := p.query(filter, tok)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read one extra document to learn whether another page follows
+	opts := options.Find().SetSort(p.sort(t.Before)).SetLimit(p.limit + 1)
+	cursor, err := coll.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var docs []bson.Raw
+	for cursor.Next(ctx) {
+		docs = append(docs, append(bson.Raw(nil), cursor.Current...))
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	more := int64(len(docs)) > p.limit
+	if more {
+		docs = docs[:p.limit]
+	}
+	hasNext, hasPrev := more, tok != ""
+	if t.Before {
+		for i, j := 0, len(docs)-1; i < j; i, j = i+1, j-1 {
+			docs[i], docs[j] = docs[j], docs[i]
+		}
+		hasNext, hasPrev = true, more
+	}
+
+	page := &Page{Documents: docs}
+	if len(docs) == 0 {
+		return page, nil
+	}
+	if hasNext {
+		if page.Next, err = p.After(docs[len(docs)-1]); err != nil {
+			return nil, err
+		}
+	}
+	if hasPrev {
+		if page.Prev, err = p.Before(docs[0]); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
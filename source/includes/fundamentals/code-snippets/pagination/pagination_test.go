@@ -0,0 +1,422 @@
+package pagination
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Course struct {
+	Title      string
+	Enrollment int32
+}
+
+var courseSort = bson.D{{"enrollment", -1}, {"title", 1}}
+
+func marshal(t testing.TB, v interface{}) bson.Raw {
+	t.Helper()
+	b, err := bson.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func mustNew(t testing.TB, sort bson.D, limit int64) *Paginator {
+	t.Helper()
+	p, err := New(sort, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// after returns the token for the page after doc.
+func after(t testing.TB, p *Paginator, doc bson.Raw) string {
+	t.Helper()
+	tok, err := p.After(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+// before returns the token for the page before doc.
+func before(t testing.TB, p *Paginator, doc bson.Raw) string {
+	t.Helper()
+	tok, err := p.Before(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+// TestQuery compares the filters and sort documents of the Paginator to
+// literals.
+func TestQuery(t *testing.T) {
+	id := primitive.NewObjectID()
+	algebra := marshal(t, bson.D{{"_id", id}, {"title", "Abstract Algebra"}, {"enrollment", int32(60)}})
+	p := mustNew(t, courseSort, 2)
+	byID := mustNew(t, bson.D{}, 2)
+	byIDDesc := mustNew(t, bson.D{{"_id", -1}}, 2)
+	nested := mustNew(t, bson.D{{"address.city", 1}}, 2)
+	vendor := marshal(t, bson.D{{"_id", id}, {"address", bson.D{{"city", "Lisbon"}}}})
+
+	tests := []struct {
+		name       string
+		p          *Paginator
+		filter     interface{}
+		token      string
+		wantFilter interface{}
+		wantSort   bson.D
+	}{
+		{"first page", p, nil, "",
+			bson.D{}, bson.D{{"enrollment", -1}, {"title", 1}, {"_id", 1}}},
+		{"first page with a filter", p, bson.D{{"enrollment", bson.D{{"$gt", 20}}}}, "",
+			bson.D{{"enrollment", bson.D{{"$gt", 20}}}}, bson.D{{"enrollment", -1}, {"title", 1}, {"_id", 1}}},
+		{"after a document", p, nil, after(t, p, algebra),
+			bson.D{{"$or", bson.A{
+				bson.D{{"enrollment", bson.D{{"$lt", int32(60)}}}},
+				bson.D{{"enrollment", bson.D{{"$eq", int32(60)}}}, {"title", bson.D{{"$gt", "Abstract Algebra"}}}},
+				bson.D{{"enrollment", bson.D{{"$eq", int32(60)}}}, {"title", bson.D{{"$eq", "Abstract Algebra"}}}, {"_id", bson.D{{"$gt", id}}}},
+			}}},
+			bson.D{{"enrollment", -1}, {"title", 1}, {"_id", 1}}},
+		{"before a document", p, nil, before(t, p, algebra),
+			bson.D{{"$or", bson.A{
+				bson.D{{"enrollment", bson.D{{"$gt", int32(60)}}}},
+				bson.D{{"enrollment", bson.D{{"$eq", int32(60)}}}, {"title", bson.D{{"$lt", "Abstract Algebra"}}}},
+				bson.D{{"enrollment", bson.D{{"$eq", int32(60)}}}, {"title", bson.D{{"$eq", "Abstract Algebra"}}}, {"_id", bson.D{{"$lt", id}}}},
+			}}},
+			bson.D{{"enrollment", 1}, {"title", -1}, {"_id", -1}}},
+		{"after a document with a filter", p, bson.D{{"title", bson.D{{"$regex", "^A"}}}}, after(t, p, algebra),
+			bson.D{{"$and", bson.A{
+				bson.D{{"title", bson.D{{"$regex", "^A"}}}},
+				bson.D{{"$or", bson.A{
+					bson.D{{"enrollment", bson.D{{"$lt", int32(60)}}}},
+					bson.D{{"enrollment", bson.D{{"$eq", int32(60)}}}, {"title", bson.D{{"$gt", "Abstract Algebra"}}}},
+					bson.D{{"enrollment", bson.D{{"$eq", int32(60)}}}, {"title", bson.D{{"$eq", "Abstract Algebra"}}}, {"_id", bson.D{{"$gt", id}}}},
+				}}},
+			}}},
+			bson.D{{"enrollment", -1}, {"title", 1}, {"_id", 1}}},
+		{"sorted by _id", byID, nil, after(t, byID, algebra),
+			bson.D{{"_id", bson.D{{"$gt", id}}}}, bson.D{{"_id", 1}}},
+		{"sorted by _id, descending", byIDDesc, nil, after(t, byIDDesc, algebra),
+			bson.D{{"_id", bson.D{{"$lt", id}}}}, bson.D{{"_id", -1}}},
+		{"sorted by _id, descending, backward", byIDDesc, nil, before(t, byIDDesc, algebra),
+			bson.D{{"_id", bson.D{{"$gt", id}}}}, bson.D{{"_id", 1}}},
+		{"sorted by an embedded field", nested, nil, after(t, nested, vendor),
+			bson.D{{"$or", bson.A{
+				bson.D{{"address.city", bson.D{{"$gt", "Lisbon"}}}},
+				bson.D{{"address.city", bson.D{{"$eq", "Lisbon"}}}, {"_id", bson.D{{"$gt", id}}}},
+			}}},
+			bson.D{{"address.city", 1}, {"_id", 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, sort, err := tt.p.Query(tt.filter, tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(marshal(t, filter), marshal(t, tt.wantFilter)) {
+				t.Errorf("got the filter %v, want %v", marshal(t, filter), marshal(t, tt.wantFilter))
+			}
+			if !bytes.Equal(marshal(t, sort), marshal(t, tt.wantSort)) {
+				t.Errorf("got the sort %v, want %v", sort, tt.wantSort)
+			}
+		})
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		sort  bson.D
+		limit int64
+	}{
+		{"direction 2", bson.D{{"enrollment", 2}}, 2},
+		{"direction desc", bson.D{{"enrollment", "desc"}}, 2},
+		{"empty key", bson.D{{"", 1}}, 2},
+		{"operator key", bson.D{{"$natural", 1}}, 2},
+		{"limit 0", courseSort, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.sort, tt.limit); err == nil {
+				t.Error("New() returned no error")
+			}
+		})
+	}
+}
+
+func TestQueryInvalidToken(t *testing.T) {
+	algebra := marshal(t, bson.D{{"_id", primitive.NewObjectID()}, {"title", "Abstract Algebra"}, {"enrollment", int32(60)}})
+	p := mustNew(t, courseSort, 2)
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"not base64", "not a token!"},
+		{"not BSON", "bm90IGJzb24"},
+		{"other keys", after(t, mustNew(t, bson.D{}, 2), algebra)},
+		{"other directions", after(t, mustNew(t, bson.D{{"enrollment", 1}, {"title", 1}}, 2), algebra)},
+		{"other order", after(t, mustNew(t, bson.D{{"title", 1}, {"enrollment", -1}}, 2), algebra)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := p.Query(nil, tt.token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("got %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestAfterInvalid(t *testing.T) {
+	id := primitive.NewObjectID()
+	p := mustNew(t, courseSort, 2)
+	tests := []struct {
+		name string
+		doc  bson.D
+	}{
+		{"missing key", bson.D{{"_id", id}, {"title", "Abstract Algebra"}}},
+		{"null value", bson.D{{"_id", id}, {"title", "Abstract Algebra"}, {"enrollment", nil}}},
+		{"array value", bson.D{{"_id", id}, {"title", bson.A{"Abstract Algebra"}}, {"enrollment", 60}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.After(marshal(t, tt.doc)); err == nil {
+				t.Error("After() returned no error")
+			}
+		})
+	}
+}
+
+// testCollection returns a collection of courses in which several courses
+// have the same enrollment. It skips the test if MONGODB_URI isn't set.
+func testCollection(t *testing.T) *mongo.Collection {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		t.Skip("set MONGODB_URI to run the test")
+	}
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.TODO()) })
+
+	coll := client.Database("db").Collection("courses_find_test")
+	coll.Drop(context.TODO())
+	t.Cleanup(func() { coll.Drop(context.TODO()) })
+	courses := []interface{}{
+		Course{Title: "World Fiction", Enrollment: 35},
+		Course{Title: "Abstract Algebra", Enrollment: 60},
+		Course{Title: "Modern Poetry", Enrollment: 12},
+		Course{Title: "Plate Tectonics", Enrollment: 35},
+		Course{Title: "Calculus", Enrollment: 60},
+		Course{Title: "Organic Chemistry", Enrollment: 20},
+		Course{Title: "Ancient History", Enrollment: 35},
+		Course{Title: "Linear Algebra", Enrollment: 12},
+	}
+	if _, err := coll.InsertMany(context.TODO(), courses); err != nil {
+		t.Fatal(err)
+	}
+	return coll
+}
+
+// titles returns the titles of docs.
+func titles(docs []bson.Raw) []string {
+	values := []string{}
+	for _, doc := range docs {
+		values = append(values, doc.Lookup("title").StringValue())
+	}
+	return values
+}
+
+// check compares the printed forms of got and want.
+func check(t *testing.T, what string, got, want interface{}) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%s: got %v, want %v", what, got, want)
+	}
+}
+
+// TestFind pages forward through the courses with Next and back with Prev,
+// and compares the pages to the results of a sorted Find.
+func TestFind(t *testing.T) {
+	coll := testCollection(t)
+	filters := []struct {
+		name   string
+		filter bson.D
+	}{
+		{"all courses", bson.D{}},
+		{"filter", bson.D{{"enrollment", bson.D{{"$gte", 20}}}}},
+		{"no results", bson.D{{"enrollment", bson.D{{"$gt", 100}}}}},
+	}
+	for _, f := range filters {
+		for limit := int64(1); limit <= 9; limit++ {
+			t.Run(fmt.Sprintf("%s/limit=%d", f.name, limit), func(t *testing.T) {
+				p := mustNew(t, courseSort, limit)
+				cursor, err := coll.Find(context.TODO(), f.filter, options.Find().SetSort(p.Sort()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				var all []bson.Raw
+				if err := cursor.All(context.TODO(), &all); err != nil {
+					t.Fatal(err)
+				}
+				want := titles(all)
+
+				var pages []*Page
+				var got []string
+				for tok := ""; ; {
+					page, err := p.Find(context.TODO(), coll, f.filter, tok)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if int64(len(page.Documents)) > limit {
+						t.Fatalf("page %d has %d documents, want at most %d", len(pages), len(page.Documents), limit)
+					}
+					pages = append(pages, page)
+					got = append(got, titles(page.Documents)...)
+					if page.Next == "" {
+						break
+					}
+					if len(pages) > len(want) {
+						t.Fatalf("got more than %d pages", len(want))
+					}
+					tok = page.Next
+				}
+				check(t, "titles", got, want)
+				wantPages := (int64(len(want)) + limit - 1) / limit
+				if wantPages == 0 {
+					// A query without results returns one empty page
+					wantPages = 1
+				}
+				check(t, "pages", len(pages), wantPages)
+				check(t, "Prev of the first page", pages[0].Prev, "")
+
+				// Page back from the last page. Each page must have the same
+				// documents as on the way forward, and a Next token.
+				last := pages[len(pages)-1]
+				for i := len(pages) - 2; i >= 0; i-- {
+					if last.Prev == "" {
+						t.Fatalf("page %d has no Prev token", i+1)
+					}
+					page, err := p.Find(context.TODO(), coll, f.filter, last.Prev)
+					if err != nil {
+						t.Fatal(err)
+					}
+					check(t, fmt.Sprintf("titles of page %d", i), titles(page.Documents), titles(pages[i].Documents))
+					check(t, fmt.Sprintf("Next of page %d", i), page.Next == "", false)
+					if i == 0 {
+						check(t, "Prev of the first page read backward", page.Prev, "")
+					}
+					last = page
+				}
+			})
+		}
+	}
+}
+
+const (
+	benchDocs     = 1000000
+	benchPageSize = 20
+)
+
+// benchOffsets are the offsets of the pages that the benchmarks read.
+var benchOffsets = []int{0, 1000, 10000, 100000, benchDocs / 2, benchDocs - benchPageSize}
+
+// benchCollection returns a collection of benchDocs courses with an index on
+// the sort keys of p, and inserts the courses if the collection doesn't
+// contain them yet. It skips the benchmark if MONGODB_URI isn't set.
+func benchCollection(b *testing.B, p *Paginator) *mongo.Collection {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		b.Skip("set MONGODB_URI to run the benchmark")
+	}
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { client.Disconnect(context.TODO()) })
+
+	coll := client.Database("db").Collection("courses_bench")
+	count, err := coll.CountDocuments(context.TODO(), bson.D{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if count != benchDocs {
+		coll.Drop(context.TODO())
+		r := rand.New(rand.NewSource(1))
+		batch := make([]interface{}, 0, 10000)
+		for i := 0; i < benchDocs; i++ {
+			batch = append(batch, Course{Title: fmt.Sprintf("Course %07d", i), Enrollment: r.Int31n(500)})
+			if len(batch) == cap(batch) || i == benchDocs-1 {
+				if _, err := coll.InsertMany(context.TODO(), batch, options.InsertMany().SetOrdered(false)); err != nil {
+					b.Fatal(err)
+				}
+				batch = batch[:0]
+			}
+		}
+	}
+	if _, err := coll.Indexes().CreateOne(context.TODO(), mongo.IndexModel{Keys: p.Sort()}); err != nil {
+		b.Fatal(err)
+	}
+	return coll
+}
+
+// BenchmarkSkip reads pages at increasing offsets with SetSkip().
+func BenchmarkSkip(b *testing.B) {
+	p := mustNew(b, courseSort, benchPageSize)
+	coll := benchCollection(b, p)
+	for _, offset := range benchOffsets {
+		b.Run(fmt.Sprintf("offset=%d", offset), func(b *testing.B) {
+			opts := options.Find().SetSort(p.Sort()).SetSkip(int64(offset)).SetLimit(benchPageSize)
+			for i := 0; i < b.N; i++ {
+				cursor, err := coll.Find(context.TODO(), bson.D{}, opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+				var page []bson.Raw
+				if err = cursor.All(context.TODO(), &page); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkKeyset reads the same pages as BenchmarkSkip with tokens.
+func BenchmarkKeyset(b *testing.B) {
+	p := mustNew(b, courseSort, benchPageSize)
+	coll := benchCollection(b, p)
+	for _, offset := range benchOffsets {
+		b.Run(fmt.Sprintf("offset=%d", offset), func(b *testing.B) {
+			// Build the token from the document before the page, as the
+			// previous page would
+			var tok string
+			if offset > 0 {
+				opts := options.FindOne().SetSort(p.Sort()).SetSkip(int64(offset - 1))
+				doc, err := coll.FindOne(context.TODO(), bson.D{}, opts).DecodeBytes()
+				if err != nil {
+					b.Fatal(err)
+				}
+				tok = after(b, p, doc)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := p.Find(context.TODO(), coll, bson.D{}, tok); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
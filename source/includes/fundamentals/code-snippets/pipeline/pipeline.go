@@ -0,0 +1,286 @@
+// Package pipeline builds aggregation pipeline stages from typed
+// constructors, so that the compiler catches a misspelled stage or
+// accumulator name.
+//
+// Each constructor returns one stage. Combine stages in a mongo.Pipeline:
+//
+//	p := mongo.Pipeline{
+//		pipeline.Match(bson.D{{"toppings", "milk foam"}}),
+//		pipeline.Sort(pipeline.Asc("price")),
+//		pipeline.Limit(2),
+//	}
+package pipeline
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Ref returns the expression that refers to the value of a field, such as
+// "$price" for the price field.
+func Ref(field string) string {
+	return "$" + field
+}
+
+// Match returns a $match stage that passes the documents that match
+// filter.
+func Match(filter interface{}) bson.D {
+	return bson.D{{"$match", filter}}
+}
+
+// Accumulator calculates one field of each group in a $group stage. Create
+// one with a constructor such as Sum() or Avg(), which sets the operator.
+type Accumulator struct {
+	field    string
+	operator string
+	expr     interface{}
+}
+
+func (a Accumulator) element() bson.E {
+	return bson.E{a.field, bson.D{{a.operator, a.expr}}}
+}
+
+// Sum sets field to the sum of expr over the documents of each group.
+func Sum(field string, expr interface{}) Accumulator {
+	return Accumulator{field, "$sum", expr}
+}
+
+// Count sets field to the number of documents in each group.
+func Count(field string) Accumulator {
+	return Sum(field, 1)
+}
+
+// Avg sets field to the average of expr over the documents of each group.
+func Avg(field string, expr interface{}) Accumulator {
+	return Accumulator{field, "$avg", expr}
+}
+
+// Min sets field to the lowest value of expr in each group.
+func Min(field string, expr interface{}) Accumulator {
+	return Accumulator{field, "$min", expr}
+}
+
+// Max sets field to the highest value of expr in each group.
+func Max(field string, expr interface{}) Accumulator {
+	return Accumulator{field, "$max", expr}
+}
+
+// First sets field to the value of expr in the first document of each
+// group.
+func First(field string, expr interface{}) Accumulator {
+	return Accumulator{field, "$first", expr}
+}
+
+// Last sets field to the value of expr in the last document of each group.
+func Last(field string, expr interface{}) Accumulator {
+	return Accumulator{field, "$last", expr}
+}
+
+// Push sets field to an array of the values of expr in each group.
+func Push(field string, expr interface{}) Accumulator {
+	return Accumulator{field, "$push", expr}
+}
+
+// AddToSet sets field to an array of the distinct values of expr in each
+// group.
+func AddToSet(field string, expr interface{}) Accumulator {
+	return Accumulator{field, "$addToSet", expr}
+}
+
+// Group returns a $group stage that groups documents by id and calculates
+// the accumulators for each group. Pass nil as id to group all documents
+// together.
+func Group(id interface{}, accumulators ...Accumulator) bson.D {
+	group := bson.D{{"_id", id}}
+	for _, a := range accumulators {
+		group = append(group, a.element())
+	}
+	return bson.D{{"$group", group}}
+}
+
+// Projection sets one field in a $project stage.
+type Projection bson.E
+
+// Include keeps a field in the output documents.
+func Include(field string) Projection {
+	return Projection{field, 1}
+}
+
+// Exclude removes a field from the output documents.
+func Exclude(field string) Projection {
+	return Projection{field, 0}
+}
+
+// Compute sets a field to the value of expr.
+func Compute(field string, expr interface{}) Projection {
+	return Projection{field, expr}
+}
+
+// Project returns a $project stage with the projections in order.
+func Project(projections ...Projection) bson.D {
+	project := make(bson.D, len(projections))
+	for i, p := range projections {
+		project[i] = bson.E(p)
+	}
+	return bson.D{{"$project", project}}
+}
+
+// Unset returns an $unset stage that removes fields from the documents.
+func Unset(fields ...string) bson.D {
+	list := make(bson.A, len(fields))
+	for i, field := range fields {
+		list[i] = field
+	}
+	return bson.D{{"$unset", list}}
+}
+
+// SortKey is one field of a $sort stage.
+type SortKey bson.E
+
+// Asc sorts by a field in ascending order.
+func Asc(field string) SortKey {
+	return SortKey{field, 1}
+}
+
+// Desc sorts by a field in descending order.
+func Desc(field string) SortKey {
+	return SortKey{field, -1}
+}
+
+// Sort returns a $sort stage that sorts by the keys in order.
+func Sort(keys ...SortKey) bson.D {
+	sort := make(bson.D, len(keys))
+	for i, key := range keys {
+		sort[i] = bson.E(key)
+	}
+	return bson.D{{"$sort", sort}}
+}
+
+// Limit returns a $limit stage that passes the first n documents.
+func Limit(n int) bson.D {
+	return bson.D{{"$limit", n}}
+}
+
+// Skip returns a $skip stage that skips the first n documents.
+func Skip(n int) bson.D {
+	return bson.D{{"$skip", n}}
+}
+
+// Lookup returns a $lookup stage that sets the as field to the documents
+// of the from collection whose foreignField equals localField.
+func Lookup(from, localField, foreignField, as string) bson.D {
+	return bson.D{{"$lookup", bson.D{
+		{"from", from},
+		{"localField", localField},
+		{"foreignField", foreignField},
+		{"as", as},
+	}}}
+}
+
+// LookupPipeline returns a $lookup stage that runs p on the from
+// collection for each document and sets the as field to the results. The
+// let variables are available to p, for example as $$name.
+func LookupPipeline(from string, let bson.D, p mongo.Pipeline, as string) bson.D {
+	lookup := bson.D{{"from", from}}
+	if len(let) > 0 {
+		lookup = append(lookup, bson.E{"let", let})
+	}
+	lookup = append(lookup, bson.E{"pipeline", p}, bson.E{"as", as})
+	return bson.D{{"$lookup", lookup}}
+}
+
+// UnwindOptions sets the optional fields of an $unwind stage.
+type UnwindOptions struct {
+	// IncludeArrayIndex names a field for the index of the element
+	IncludeArrayIndex string
+	// PreserveNullAndEmptyArrays passes documents whose array is missing,
+	// null, or empty
+	PreserveNullAndEmptyArrays bool
+}
+
+// Unwind returns an $unwind stage that outputs one document for each
+// element of the array in field.
+func Unwind(field string) bson.D {
+	return bson.D{{"$unwind", Ref(field)}}
+}
+
+// UnwindWith returns an $unwind stage with options.
+func UnwindWith(field string, opts UnwindOptions) bson.D {
+	unwind := bson.D{{"path", Ref(field)}}
+	if opts.IncludeArrayIndex != "" {
+		unwind = append(unwind, bson.E{"includeArrayIndex", opts.IncludeArrayIndex})
+	}
+	if opts.PreserveNullAndEmptyArrays {
+		unwind = append(unwind, bson.E{"preserveNullAndEmptyArrays", true})
+	}
+	return bson.D{{"$unwind", unwind}}
+}
+
+// FacetPipeline is one named pipeline of a $facet stage.
+type FacetPipeline struct {
+	Name     string
+	Pipeline mongo.Pipeline
+}
+
+// Facet returns a $facet stage that runs each pipeline on the same input
+// documents and outputs one document with a field for each pipeline.
+func Facet(facets ...FacetPipeline) bson.D {
+	facet := make(bson.D, len(facets))
+	for i, f := range facets {
+		facet[i] = bson.E{f.Name, f.Pipeline}
+	}
+	return bson.D{{"$facet", facet}}
+}
+
+// Values of MergeOptions.WhenMatched and MergeOptions.WhenNotMatched.
+const (
+	WhenMatchedReplace      = "replace"
+	WhenMatchedKeepExisting = "keepExisting"
+	WhenMatchedMerge        = "merge"
+	WhenMatchedFail         = "fail"
+	WhenNotMatchedInsert    = "insert"
+	WhenNotMatchedDiscard   = "discard"
+	WhenNotMatchedFail      = "fail"
+)
+
+// MergeOptions sets the optional fields of a $merge stage. The server uses
+// its defaults for the fields that you don't set.
+type MergeOptions struct {
+	// DB is the database of the target collection, if it differs from the
+	// database of the aggregation
+	DB string
+	// On lists the fields that identify a matching document
+	On []string
+	// WhenMatched is one of the WhenMatched constants, or a pipeline that
+	// updates the matching document
+	WhenMatched interface{}
+	// WhenNotMatched is one of the WhenNotMatched constants
+	WhenNotMatched string
+}
+
+// Merge returns a $merge stage that writes the results to the into
+// collection.
+func Merge(into string, opts MergeOptions) bson.D {
+	merge := bson.D{{"into", into}}
+	if opts.DB != "" {
+		merge[0].Value = bson.D{{"db", opts.DB}, {"coll", into}}
+	}
+	switch len(opts.On) {
+	case 0:
+	case 1:
+		merge = append(merge, bson.E{"on", opts.On[0]})
+	default:
+		on := make(bson.A, len(opts.On))
+		for i, field := range opts.On {
+			on[i] = field
+		}
+		merge = append(merge, bson.E{"on", on})
+	}
+	if opts.WhenMatched != nil {
+		merge = append(merge, bson.E{"whenMatched", opts.WhenMatched})
+	}
+	if opts.WhenNotMatched != "" {
+		merge = append(merge, bson.E{"whenNotMatched", opts.WhenNotMatched})
+	}
+	return bson.D{{"$merge", merge}}
+}
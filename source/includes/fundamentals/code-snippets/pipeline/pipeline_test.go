@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"bytes"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// sameBSON reports whether a and b marshal to the same BSON bytes.
+func sameBSON(t *testing.T, a, b interface{}) bool {
+	t.Helper()
+	rawA, err := bson.Marshal(bson.D{{"v", a}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawB, err := bson.Marshal(bson.D{{"v", b}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Equal(rawA, rawB)
+}
+
+// TestStages compares the stage that each constructor builds to the same
+// stage written as a bson.D literal.
+func TestStages(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder bson.D
+		raw     bson.D
+	}{
+		{"Match", Match(bson.D{{"toppings", "milk foam"}}),
+			bson.D{{"$match", bson.D{{"toppings", "milk foam"}}}}},
+		{"Group", Group(Ref("category"), Avg("average_price", Ref("price")), Count("type_total")),
+			bson.D{{"$group", bson.D{{"_id", "$category"}, {"average_price", bson.D{{"$avg", "$price"}}}, {"type_total", bson.D{{"$sum", 1}}}}}}},
+		{"Group with nil _id", Group(nil, Min("min", "$price"), Max("max", "$price"), Push("types", "$type")),
+			bson.D{{"$group", bson.D{{"_id", nil}, {"min", bson.D{{"$min", "$price"}}}, {"max", bson.D{{"$max", "$price"}}}, {"types", bson.D{{"$push", "$type"}}}}}}},
+		{"Project", Project(Exclude("_id"), Include("type"), Compute("cups", bson.D{{"$sum", "$orders.quantity"}})),
+			bson.D{{"$project", bson.D{{"_id", 0}, {"type", 1}, {"cups", bson.D{{"$sum", "$orders.quantity"}}}}}}},
+		{"Unset", Unset("_id", "category"),
+			bson.D{{"$unset", bson.A{"_id", "category"}}}},
+		{"Sort", Sort(Desc("revenue"), Asc("type")),
+			bson.D{{"$sort", bson.D{{"revenue", -1}, {"type", 1}}}}},
+		{"Limit", Limit(2), bson.D{{"$limit", 2}}},
+		{"Skip", Skip(5), bson.D{{"$skip", 5}}},
+		{"Lookup", Lookup("orders", "type", "tea", "orders"),
+			bson.D{{"$lookup", bson.D{{"from", "orders"}, {"localField", "type"}, {"foreignField", "tea"}, {"as", "orders"}}}}},
+		{"LookupPipeline",
+			LookupPipeline("orders", bson.D{{"teaType", "$type"}}, mongo.Pipeline{
+				Match(bson.D{{"$expr", bson.D{{"$eq", bson.A{"$tea", "$$teaType"}}}}}),
+				Project(Exclude("_id"), Include("quantity")),
+			}, "orders"),
+			bson.D{{"$lookup", bson.D{
+				{"from", "orders"},
+				{"let", bson.D{{"teaType", "$type"}}},
+				{"pipeline", bson.A{
+					bson.D{{"$match", bson.D{{"$expr", bson.D{{"$eq", bson.A{"$tea", "$$teaType"}}}}}}},
+					bson.D{{"$project", bson.D{{"_id", 0}, {"quantity", 1}}}},
+				}},
+				{"as", "orders"},
+			}}}},
+		{"Unwind", Unwind("toppings"), bson.D{{"$unwind", "$toppings"}}},
+		{"UnwindWith", UnwindWith("toppings", UnwindOptions{IncludeArrayIndex: "position", PreserveNullAndEmptyArrays: true}),
+			bson.D{{"$unwind", bson.D{{"path", "$toppings"}, {"includeArrayIndex", "position"}, {"preserveNullAndEmptyArrays", true}}}}},
+		{"Facet",
+			Facet(
+				FacetPipeline{"categories", mongo.Pipeline{Group(Ref("category"), Count("count"))}},
+				FacetPipeline{"cheapest", mongo.Pipeline{Sort(Asc("price")), Limit(3)}},
+			),
+			bson.D{{"$facet", bson.D{
+				{"categories", bson.A{bson.D{{"$group", bson.D{{"_id", "$category"}, {"count", bson.D{{"$sum", 1}}}}}}}},
+				{"cheapest", bson.A{bson.D{{"$sort", bson.D{{"price", 1}}}}, bson.D{{"$limit", 3}}}},
+			}}}},
+		{"Merge", Merge("tea_sales", MergeOptions{On: []string{"_id"}, WhenMatched: WhenMatchedReplace, WhenNotMatched: WhenNotMatchedInsert}),
+			bson.D{{"$merge", bson.D{{"into", "tea_sales"}, {"on", "_id"}, {"whenMatched", "replace"}, {"whenNotMatched", "insert"}}}}},
+		{"Merge into another database", Merge("tea_sales", MergeOptions{DB: "reports", On: []string{"tea", "day"}}),
+			bson.D{{"$merge", bson.D{{"into", bson.D{{"db", "reports"}, {"coll", "tea_sales"}}}, {"on", bson.A{"tea", "day"}}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !sameBSON(t, tt.builder, tt.raw) {
+				t.Errorf("got %v, want %v", tt.builder, tt.raw)
+			}
+		})
+	}
+}
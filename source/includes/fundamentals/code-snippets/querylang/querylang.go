@@ -0,0 +1,418 @@
+// Package querylang compiles a small query language into query filters, so
+// that an application can accept filters from users without passing their
+// input to Find() as raw BSON.
+//
+// An expression compares fields to literal values and combines the
+// comparisons:
+//
+//	enrollment >= 20 AND title ~ "Modern"
+//	NOT (title = "World Fiction" OR enrollment IN (12, 15))
+//
+// The language has the following operators:
+//
+//   - = != < <= > >= compare a field to a value
+//   - ~ matches strings that contain the value
+//   - IN (v1, v2, ...) matches any of the values
+//   - AND, OR, and NOT combine expressions, and parentheses group them
+//
+// Values are double-quoted strings, numbers, true, false, and null.
+// Keywords are case-insensitive.
+//
+// Users can only refer to the fields in the Schema, and can't use query
+// operators such as $where, because the compiler writes every operator in
+// the filter itself. Values are always literals.
+package querylang
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Limits on the input protect the parser and the server from expensive
+// queries.
+const (
+	MaxLength = 1024
+	MaxDepth  = 16
+	MaxValues = 100
+)
+
+// FieldType is the type of the values that a field accepts.
+type FieldType int
+
+const (
+	String FieldType = iota
+	Number
+	Bool
+)
+
+func (t FieldType) String() string {
+	switch t {
+	case String:
+		return "string"
+	case Number:
+		return "number"
+	case Bool:
+		return "bool"
+	}
+	return "unknown"
+}
+
+// Schema maps each field that users can query to the type of its values.
+// The keys are the names in the query language and in the documents.
+type Schema map[string]FieldType
+
+// Error describes invalid input and the byte offset where the problem
+// starts.
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("querylang: %s at offset %d", e.Msg, e.Pos)
+}
+
+// Compile parses input and returns the equivalent filter. It returns an
+// *Error if the input is invalid or refers to a field outside the schema.
+func (s Schema) Compile(input string) (bson.D, error) {
+	if len(input) > MaxLength {
+		return nil, &Error{MaxLength, fmt.Sprintf("the query is longer than %d bytes", MaxLength)}
+	}
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{schema: s, tokens: tokens}
+	filter, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, &Error{t.pos, fmt.Sprintf("unexpected %s", t)}
+	}
+	return filter, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of query"
+	case tokenString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// keyword reports whether t is the keyword word, in any case.
+func (t token) keyword(word string) bool {
+	return t.kind == tokenIdent && strings.EqualFold(t.text, word)
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func lex(input string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(input); {
+		r, size := utf8.DecodeRuneInString(input[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			return nil, &Error{i, "invalid UTF-8"}
+		case unicode.IsSpace(r):
+			i += size
+		case r == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+		case strings.ContainsRune("=!<>~", r):
+			op := input[i : i+1]
+			if i+1 < len(input) && input[i+1] == '=' && r != '=' && r != '~' {
+				op = input[i : i+2]
+			}
+			if op == "!" {
+				return nil, &Error{i, `unexpected "!"`}
+			}
+			tokens = append(tokens, token{tokenOperator, op, i})
+			i += len(op)
+		case r == '"':
+			text, n, err := lexString(input[i:])
+			if err != nil {
+				return nil, &Error{i, err.Error()}
+			}
+			if !utf8.ValidString(text) {
+				return nil, &Error{i, "invalid UTF-8 in a string"}
+			}
+			tokens = append(tokens, token{tokenString, text, i})
+			i += n
+		case r == '-' || r == '+' || r == '.' || unicode.IsDigit(r):
+			j := i + 1
+			for j < len(input) && strings.IndexByte("0123456789.eE+-", input[j]) >= 0 {
+				j++
+			}
+			tokens = append(tokens, token{tokenNumber, input[i:j], i})
+			i = j
+		case isIdentStart(r):
+			j := i + size
+			for j < len(input) {
+				r, size := utf8.DecodeRuneInString(input[j:])
+				if !isIdentPart(r) {
+					break
+				}
+				j += size
+			}
+			tokens = append(tokens, token{tokenIdent, input[i:j], i})
+			i = j
+		default:
+			return nil, &Error{i, fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(input)}), nil
+}
+
+// lexString reads a double-quoted string that starts at the beginning of
+// input. It returns the string and the number of bytes that it read.
+func lexString(input string) (string, int, error) {
+	var sb strings.Builder
+	for i := 1; i < len(input); i++ {
+		switch c := input[i]; c {
+		case '"':
+			return sb.String(), i + 1, nil
+		case '\\':
+			i++
+			if i == len(input) || (input[i] != '"' && input[i] != '\\') {
+				return "", 0, fmt.Errorf(`a string can only escape " and \`)
+			}
+			sb.WriteByte(input[i])
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+type parser struct {
+	schema Schema
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+// parseOr parses expressions separated by OR. The depth counts the
+// nesting of parentheses and NOT.
+func (p *parser) parseOr(depth int) (bson.D, error) {
+	return p.parseList("OR", "$or", depth, p.parseAnd)
+}
+
+func (p *parser) parseAnd(depth int) (bson.D, error) {
+	return p.parseList("AND", "$and", depth, p.parseUnary)
+}
+
+// parseList parses operands separated by keyword and combines two or more
+// operands with operator.
+func (p *parser) parseList(keyword, operator string, depth int, operand func(int) (bson.D, error)) (bson.D, error) {
+	first, err := operand(depth)
+	if err != nil {
+		return nil, err
+	}
+	list := bson.A{first}
+	for p.peek().keyword(keyword) {
+		p.advance()
+		next, err := operand(depth)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, next)
+	}
+	if len(list) == 1 {
+		return first, nil
+	}
+	return bson.D{{operator, list}}, nil
+}
+
+func (p *parser) parseUnary(depth int) (bson.D, error) {
+	t := p.peek()
+	if depth >= MaxDepth && (t.kind == tokenLParen || t.keyword("NOT")) {
+		return nil, &Error{t.pos, fmt.Sprintf("the query is nested more than %d levels deep", MaxDepth)}
+	}
+	switch {
+	case t.keyword("NOT"):
+		p.advance()
+		operand, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		// $not applies to one field, so $nor negates a whole expression
+		return bson.D{{"$nor", bson.A{operand}}}, nil
+	case t.kind == tokenLParen:
+		p.advance()
+		filter, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if t := p.advance(); t.kind != tokenRParen {
+			return nil, &Error{t.pos, fmt.Sprintf("expected \")\", found %s", t)}
+		}
+		return filter, nil
+	}
+	return p.parseComparison()
+}
+
+var comparisonOperators = map[string]string{
+	"!=": "$ne",
+	"<":  "$lt",
+	"<=": "$lte",
+	">":  "$gt",
+	">=": "$gte",
+}
+
+func (p *parser) parseComparison() (bson.D, error) {
+	field := p.advance()
+	if field.kind != tokenIdent || isKeyword(field) {
+		return nil, &Error{field.pos, fmt.Sprintf("expected a field name, found %s", field)}
+	}
+	fieldType, ok := p.schema[field.text]
+	if !ok {
+		return nil, &Error{field.pos, fmt.Sprintf("unknown field %q", field.text)}
+	}
+
+	op := p.advance()
+	switch {
+	case op.keyword("IN"):
+		values, err := p.parseValues(fieldType)
+		if err != nil {
+			return nil, err
+		}
+		return bson.D{{field.text, bson.D{{"$in", values}}}}, nil
+	case op.kind != tokenOperator:
+		return nil, &Error{op.pos, fmt.Sprintf("expected an operator after %q, found %s", field.text, op)}
+	}
+
+	value, err := p.parseValue(fieldType)
+	if err != nil {
+		return nil, err
+	}
+	switch op.text {
+	case "=":
+		return bson.D{{field.text, value}}, nil
+	case "~":
+		s, ok := value.(string)
+		if !ok {
+			return nil, &Error{op.pos, fmt.Sprintf("~ requires a string field, and %q is a %s field", field.text, fieldType)}
+		}
+		// Escape the value, so that users can't run a slow regular
+		// expression on the server
+		return bson.D{{field.text, bson.D{{"$regex", regexp.QuoteMeta(s)}}}}, nil
+	}
+	return bson.D{{field.text, bson.D{{comparisonOperators[op.text], value}}}}, nil
+}
+
+// parseValues parses the parenthesized values after IN.
+func (p *parser) parseValues(fieldType FieldType) (bson.A, error) {
+	if t := p.advance(); t.kind != tokenLParen {
+		return nil, &Error{t.pos, fmt.Sprintf("expected \"(\" after IN, found %s", t)}
+	}
+	var values bson.A
+	for {
+		if len(values) == MaxValues {
+			return nil, &Error{p.peek().pos, fmt.Sprintf("IN accepts at most %d values", MaxValues)}
+		}
+		value, err := p.parseValue(fieldType)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		switch t := p.advance(); t.kind {
+		case tokenComma:
+		case tokenRParen:
+			return values, nil
+		default:
+			return nil, &Error{t.pos, fmt.Sprintf("expected \",\" or \")\", found %s", t)}
+		}
+	}
+}
+
+// parseValue parses a literal and checks that its type matches the field.
+// Null matches a missing field of any type.
+func (p *parser) parseValue(fieldType FieldType) (interface{}, error) {
+	t := p.advance()
+	var value interface{}
+	var valueType FieldType
+	switch {
+	case t.keyword("null"):
+		return nil, nil
+	case t.keyword("true"), t.keyword("false"):
+		value, valueType = strings.EqualFold(t.text, "true"), Bool
+	case t.kind == tokenString:
+		value, valueType = t.text, String
+	case t.kind == tokenNumber:
+		if n, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			value = n
+		} else if f, err := strconv.ParseFloat(t.text, 64); err == nil {
+			value = f
+		} else {
+			return nil, &Error{t.pos, fmt.Sprintf("invalid number %q", t.text)}
+		}
+		valueType = Number
+	default:
+		return nil, &Error{t.pos, fmt.Sprintf("expected a value, found %s", t)}
+	}
+	if valueType != fieldType {
+		return nil, &Error{t.pos, fmt.Sprintf("expected a %s value, found %s", fieldType, t)}
+	}
+	return value, nil
+}
+
+func isKeyword(t token) bool {
+	for _, word := range []string{"AND", "OR", "NOT", "IN", "true", "false", "null"} {
+		if t.keyword(word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package querylang
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// courseSchema is the schema of the courses example.
+var courseSchema = Schema{
+	"title":      String,
+	"enrollment": Number,
+}
+
+var compileTests = []struct {
+	input string
+	want  bson.D
+}{
+	{`enrollment >= 20 AND title ~ "Modern"`,
+		bson.D{{"$and", bson.A{
+			bson.D{{"enrollment", bson.D{{"$gte", int64(20)}}}},
+			bson.D{{"title", bson.D{{"$regex", "Modern"}}}},
+		}}}},
+	{`title = "World Fiction"`, bson.D{{"title", "World Fiction"}}},
+	{`enrollment != 35`, bson.D{{"enrollment", bson.D{{"$ne", int64(35)}}}}},
+	{`enrollment < 12.5`, bson.D{{"enrollment", bson.D{{"$lt", 12.5}}}}},
+	{`enrollment IN (12, 15)`, bson.D{{"enrollment", bson.D{{"$in", bson.A{int64(12), int64(15)}}}}}},
+	{`title = null`, bson.D{{"title", nil}}},
+	{`enrollment > 50 OR enrollment < 20 AND title ~ "y"`,
+		bson.D{{"$or", bson.A{
+			bson.D{{"enrollment", bson.D{{"$gt", int64(50)}}}},
+			bson.D{{"$and", bson.A{
+				bson.D{{"enrollment", bson.D{{"$lt", int64(20)}}}},
+				bson.D{{"title", bson.D{{"$regex", "y"}}}},
+			}}},
+		}}}},
+	{`not (title = "World Fiction" OR enrollment in (12, 15))`,
+		bson.D{{"$nor", bson.A{
+			bson.D{{"$or", bson.A{
+				bson.D{{"title", "World Fiction"}},
+				bson.D{{"enrollment", bson.D{{"$in", bson.A{int64(12), int64(15)}}}}},
+			}}},
+		}}}},
+	// The compiler escapes regular expression syntax in the value
+	{`title ~ "(a+)+$"`, bson.D{{"title", bson.D{{"$regex", `\(a\+\)\+\$`}}}}},
+	{`title = "$where"`, bson.D{{"title", "$where"}}},
+	{`title = "say \"hi\" \\ bye"`, bson.D{{"title", `say "hi" \ bye`}}},
+}
+
+var invalidInputs = []string{
+	``,
+	`$where = "sleep(1000)"`,
+	`title = {"$gt": ""}`,
+	`password = "x"`,
+	`a.b = 1 OR enrollment > 1`,
+	`enrollment = "20"`,
+	`title > 5`,
+	`title ~ 5`,
+	`enrollment ~ "1"`,
+	`title = "unterminated`,
+	`title = "bad \n escape"`,
+	`title == "x"`,
+	`enrollment >`,
+	`enrollment IN ()`,
+	`enrollment IN (1, 2`,
+	`enrollment = 1e999`,
+	`enrollment = 1 2`,
+	`AND = 1`,
+	`title = "x" AND`,
+	`(title = "x"`,
+	`title = "x")`,
+	`! title = "x"`,
+	strings.Repeat("(", MaxDepth+1) + `title = "x"` + strings.Repeat(")", MaxDepth+1),
+	strings.Repeat("NOT ", MaxDepth+1) + `title = "x"`,
+	`enrollment IN (` + strings.Repeat("1, ", MaxValues) + `1)`,
+	`title = "` + strings.Repeat("x", MaxLength) + `"`,
+	"title = \"\xff\"",
+}
+
+func sameBSON(t *testing.T, a, b bson.D) bool {
+	t.Helper()
+	rawA, err := bson.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawB, err := bson.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Equal(rawA, rawB)
+}
+
+// TestCompile compares compiled filters to literals and checks that
+// invalid and dangerous input returns an error.
+func TestCompile(t *testing.T) {
+	for _, tt := range compileTests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := courseSchema.Compile(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameBSON(t, got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+	for _, input := range invalidInputs {
+		t.Run(fmt.Sprintf("%.40q", input), func(t *testing.T) {
+			_, err := courseSchema.Compile(input)
+			var qlErr *Error
+			if !errors.As(err, &qlErr) {
+				t.Errorf("got %v, want a *Error", err)
+			}
+		})
+	}
+}
+
+// allowedKeys are the only keys that a compiled filter can contain
+var allowedKeys = map[string]bool{
+	"title": true, "enrollment": true,
+	"$and": true, "$or": true, "$nor": true, "$in": true, "$regex": true,
+	"$ne": true, "$lt": true, "$lte": true, "$gt": true, "$gte": true,
+}
+
+// checkKeys returns an error if a document in the filter contains a key
+// that isn't in allowedKeys.
+func checkKeys(v interface{}) error {
+	switch v := v.(type) {
+	case bson.D:
+		for _, e := range v {
+			if !allowedKeys[e.Key] {
+				return fmt.Errorf("the filter contains the key %q", e.Key)
+			}
+			if err := checkKeys(e.Value); err != nil {
+				return err
+			}
+		}
+	case bson.A:
+		for _, item := range v {
+			if err := checkKeys(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FuzzCompile checks that Compile never panics, that it returns an *Error
+// for invalid input, and that every filter that it returns contains only
+// allowed keys. A query on the $where field always returns an error.
+func FuzzCompile(f *testing.F) {
+	for _, tt := range compileTests {
+		f.Add(tt.input)
+	}
+	for _, input := range invalidInputs {
+		f.Add(input)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		filter, err := courseSchema.Compile(input)
+		if err != nil {
+			var qlErr *Error
+			if !errors.As(err, &qlErr) {
+				t.Fatalf("Compile(%q) returned %T, want a *Error", input, err)
+			}
+		} else if err := checkKeys(filter); err != nil {
+			t.Fatalf("Compile(%q): %v", input, err)
+		}
+
+		where := "$where " + input
+		if filter, err := courseSchema.Compile(where); err == nil {
+			t.Fatalf("Compile(%q) returned %v, want an error", where, filter)
+		}
+	})
+}
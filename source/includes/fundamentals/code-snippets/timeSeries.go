@@ -2,17 +2,51 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"os"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Sensor is the metadata of a reading. The server groups readings with the
+// same metadata into the same buckets.
+type Sensor struct {
+	SensorID string `bson:"sensorId"`
+	Location string `bson:"location"`
+}
+
+// Reading is a measurement in the time series collection.
+type Reading struct {
+	Timestamp   time.Time `bson:"timestamp"`
+	Sensor      Sensor    `bson:"sensor"`
+	Temperature float64   `bson:"temperature"`
+}
+
+const (
+	interval = 5 * time.Minute
+	slots    = 72
+)
+
+// temperature returns the reading of a sensor in a slot. The values change
+// linearly, so linear interpolation reproduces missing readings exactly.
+func temperature(sensor, slot int) float64 {
+	return 15 + float64(sensor) + 0.1*float64(slot)
+}
+
+// missing reports whether the sensor skipped a reading. The first and last
+// slots are never missing.
+func missing(sensor, slot int) bool {
+	return slot > 0 && slot < slots && (slot+sensor)%7 == 3
+}
+
 func main() {
+	// Time series collections require MongoDB 5.0 or later. The $densify
+	// and $fill stages require MongoDB 5.3 or later.
 	var uri string
 	if uri = os.Getenv("MONGODB_URI"); uri == "" {
 		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://docs.mongodb.com/drivers/go/current/usage-examples/")
@@ -29,29 +63,178 @@ func main() {
 		}
 	}()
 
-	client.Database("spring_weather").Collection("temperature").Drop(context.TODO())
+	client.Database("spring_weather").Collection("sensor_readings").Drop(context.TODO())
 
 	// begin create ts coll
 	db := client.Database("spring_weather")
-	tso := options.TimeSeries().SetTimeField("temperature")
-	opts := options.CreateCollection().SetTimeSeriesOptions(tso)
+	tso := options.TimeSeries().
+		SetTimeField("timestamp").
+		SetMetaField("sensor").
+		SetGranularity("minutes")
+	opts := options.CreateCollection().
+		SetTimeSeriesOptions(tso).
+		SetExpireAfterSeconds(7 * 24 * 60 * 60)
 
-	db.CreateCollection(context.TODO(), "march2022", opts)
+	if err := db.CreateCollection(context.TODO(), "sensor_readings", opts); err != nil {
+		panic(err)
+	}
 	// end create ts coll
+	coll := db.Collection("sensor_readings")
 
 	// begin check ts coll
-	command := bson.D{{"listCollections", 1}}
-	var result bson.M
-	
-	commandErr := db.RunCommand(context.TODO(), command).Decode(&result)
-	if commandErr != nil {
-		panic(commandErr)
+	command := bson.D{{"listCollections", 1}, {"filter", bson.D{{"name", "sensor_readings"}}}}
+	var result struct {
+		Cursor struct {
+			FirstBatch []struct {
+				Type    string `bson:"type"`
+				Options struct {
+					TimeSeries struct {
+						TimeField   string `bson:"timeField"`
+						MetaField   string `bson:"metaField"`
+						Granularity string `bson:"granularity"`
+					} `bson:"timeseries"`
+					ExpireAfterSeconds int64 `bson:"expireAfterSeconds"`
+				} `bson:"options"`
+			} `bson:"firstBatch"`
+		} `bson:"cursor"`
 	}
-
-	output, outputErr := json.MarshalIndent(result, "", "    ")
-	if outputErr != nil {
-		panic(outputErr)
+	if err := db.RunCommand(context.TODO(), command).Decode(&result); err != nil {
+		panic(err)
 	}
-	fmt.Printf("%s\n", output)
 	// end check ts coll
+	if len(result.Cursor.FirstBatch) != 1 {
+		log.Fatalf("listCollections returned %d collections, want 1", len(result.Cursor.FirstBatch))
+	}
+	info := result.Cursor.FirstBatch[0]
+	ts := info.Options.TimeSeries
+	fmt.Printf("type: %s, timeField: %s, metaField: %s, granularity: %s, expireAfterSeconds: %d\n",
+		info.Type, ts.TimeField, ts.MetaField, ts.Granularity, info.Options.ExpireAfterSeconds)
+	if info.Type != "timeseries" || ts.TimeField != "timestamp" || ts.MetaField != "sensor" ||
+		ts.Granularity != "minutes" || info.Options.ExpireAfterSeconds != 7*24*60*60 {
+		log.Fatal("the collection options don't match the options passed to CreateCollection()")
+	}
+
+	// Readings from the last six hours, so that the TTL doesn't remove them
+	start := time.Now().UTC().Truncate(interval).Add(-slots * interval)
+	sensors := []Sensor{
+		{SensorID: "s-100", Location: "greenhouse"},
+		{SensorID: "s-200", Location: "orchard"},
+		{SensorID: "s-300", Location: "barn"},
+	}
+
+	// begin insert batches
+	const batchSize = 50
+	var batch []interface{}
+	inserted := 0
+	for slot := 0; slot <= slots; slot++ {
+		for i, sensor := range sensors {
+			if missing(i, slot) {
+				continue
+			}
+			batch = append(batch, Reading{
+				Timestamp:   start.Add(time.Duration(slot) * interval),
+				Sensor:      sensor,
+				Temperature: temperature(i, slot),
+			})
+			if len(batch) == batchSize || (slot == slots && i == len(sensors)-1) {
+				// Unordered inserts let the server write the batch in
+				// parallel and continue after a failed document
+				res, err := coll.InsertMany(context.TODO(), batch, options.InsertMany().SetOrdered(false))
+				if err != nil {
+					panic(err)
+				}
+				inserted += len(res.InsertedIDs)
+				batch = batch[:0]
+			}
+		}
+	}
+	// end insert batches
+	fmt.Printf("Inserted %d readings\n", inserted)
+
+	// begin moving average
+	setWindowFieldsStage := bson.D{{"$setWindowFields", bson.D{
+		{"partitionBy", "$sensor.sensorId"},
+		{"sortBy", bson.D{{"timestamp", 1}}},
+		{"output", bson.D{
+			{"movingAverage", bson.D{
+				{"$avg", "$temperature"},
+				{"window", bson.D{{"range", bson.A{-30, 0}}, {"unit", "minute"}}},
+			}},
+		}},
+	}}}
+	matchStage := bson.D{{"$match", bson.D{{"sensor.sensorId", "s-100"}}}}
+	cursor, err := coll.Aggregate(context.TODO(), mongo.Pipeline{matchStage, setWindowFieldsStage})
+	if err != nil {
+		panic(err)
+	}
+	var averages []struct {
+		Timestamp     time.Time `bson:"timestamp"`
+		Temperature   float64   `bson:"temperature"`
+		MovingAverage float64   `bson:"movingAverage"`
+	}
+	if err = cursor.All(context.TODO(), &averages); err != nil {
+		panic(err)
+	}
+	// end moving average
+
+	// Compute the last moving average from the inserted values
+	var sum float64
+	var count int
+	for slot := slots - 6; slot <= slots; slot++ {
+		if !missing(0, slot) {
+			sum += temperature(0, slot)
+			count++
+		}
+	}
+	last := averages[len(averages)-1]
+	fmt.Printf("s-100 at %s: %.2f, 30 minute moving average %.2f\n",
+		last.Timestamp.Format(time.Kitchen), last.Temperature, last.MovingAverage)
+	if math.Abs(last.MovingAverage-sum/float64(count)) > 1e-9 {
+		log.Fatalf("the moving average is %v, want %v", last.MovingAverage, sum/float64(count))
+	}
+
+	// begin densify and fill
+	// $densify adds a document for each missing 5 minute step, and $fill
+	// sets the temperature of the added documents by linear interpolation
+	projectStage := bson.D{{"$project", bson.D{
+		{"_id", 0},
+		{"timestamp", 1},
+		{"temperature", 1},
+		{"sensorId", "$sensor.sensorId"},
+	}}}
+	densifyStage := bson.D{{"$densify", bson.D{
+		{"field", "timestamp"},
+		{"partitionByFields", bson.A{"sensorId"}},
+		{"range", bson.D{{"step", 5}, {"unit", "minute"}, {"bounds", "full"}}},
+	}}}
+	fillStage := bson.D{{"$fill", bson.D{
+		{"partitionBy", "$sensorId"},
+		{"sortBy", bson.D{{"timestamp", 1}}},
+		{"output", bson.D{{"temperature", bson.D{{"method", "linear"}}}}},
+	}}}
+	sortStage := bson.D{{"$sort", bson.D{{"sensorId", 1}, {"timestamp", 1}}}}
+	cursor, err = coll.Aggregate(context.TODO(), mongo.Pipeline{projectStage, densifyStage, fillStage, sortStage})
+	if err != nil {
+		panic(err)
+	}
+	var filled []struct {
+		Timestamp   time.Time `bson:"timestamp"`
+		SensorID    string    `bson:"sensorId"`
+		Temperature float64   `bson:"temperature"`
+	}
+	if err = cursor.All(context.TODO(), &filled); err != nil {
+		panic(err)
+	}
+	// end densify and fill
+
+	if want := len(sensors) * (slots + 1); len(filled) != want {
+		log.Fatalf("$densify returned %d readings, want %d", len(filled), want)
+	}
+	for i, reading := range filled {
+		sensor, slot := i/(slots+1), i%(slots+1)
+		if math.Abs(reading.Temperature-temperature(sensor, slot)) > 1e-9 {
+			log.Fatalf("%s at slot %d: temperature %v, want %v", reading.SensorID, slot, reading.Temperature, temperature(sensor, slot))
+		}
+	}
+	fmt.Printf("Filled %d missing readings, for %d readings in total\n", len(filled)-inserted, len(filled))
 }
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// rollup describes one summary collection and the size of its windows.
+type rollup struct {
+	// Name identifies the rollup in the checkpoints collection
+	Name string
+	// Unit is the $dateTrunc unit of the windows, such as "hour" or "day"
+	Unit   string
+	Target string
+}
+
+// checkpoint records the end of the last window that a rollup completed.
+type checkpoint struct {
+	Name             string    `bson:"_id"`
+	CompletedThrough time.Time `bson:"completedThrough"`
+	UpdatedAt        time.Time `bson:"updatedAt"`
+}
+
+// summary is a document in a rollup collection.
+type summary struct {
+	ID struct {
+		SensorID string    `bson:"sensorId"`
+		Window   time.Time `bson:"window"`
+	} `bson:"_id"`
+	Min   float64 `bson:"min"`
+	Max   float64 `bson:"max"`
+	Avg   float64 `bson:"avg"`
+	Count int64   `bson:"count"`
+}
+
+type job struct {
+	source      *mongo.Collection
+	checkpoints *mongo.Collection
+	rollups     []rollup
+	// lookback is the number of completed windows to calculate again, to
+	// include readings that arrived late
+	lookback int
+	now      func() time.Time
+}
+
+// windowStart returns the start of the window that contains t. Windows are
+// aligned to UTC, like the default timezone of $dateTrunc.
+func windowStart(t time.Time, unit string) time.Time {
+	t = t.UTC()
+	switch unit {
+	case "hour":
+		return t.Truncate(time.Hour)
+	case "day":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	panic("unsupported unit " + unit)
+}
+
+func windowLength(unit string) time.Duration {
+	if unit == "day" {
+		return 24 * time.Hour
+	}
+	return time.Hour
+}
+
+// run updates every rollup with the windows that closed since its
+// checkpoint.
+func (j *job) run(ctx context.Context) error {
+	for _, r := range j.rollups {
+		if err := j.runRollup(ctx, r); err != nil {
+			return fmt.Errorf("%s: %v", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// begin run rollup
+func (j *job) runRollup(ctx context.Context, r rollup) error {
+	var cp checkpoint
+	err := j.checkpoints.FindOne(ctx, bson.D{{"_id", r.Name}}).Decode(&cp)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	// Only summarize closed windows. The current window can still receive
+	// readings, so it ends the range.
+	end := windowStart(j.now(), r.Unit)
+	timeRange := bson.D{{"$lt", end}}
+	if !cp.CompletedThrough.IsZero() {
+		from := cp.CompletedThrough.Add(-time.Duration(j.lookback) * windowLength(r.Unit))
+		timeRange = append(timeRange, bson.E{"$gte", from})
+	}
+
+	matchStage := bson.D{{"$match", bson.D{{"timestamp", timeRange}}}}
+	groupStage := bson.D{{"$group", bson.D{
+		{"_id", bson.D{
+			{"sensorId", "$sensor.sensorId"},
+			{"window", bson.D{{"$dateTrunc", bson.D{{"date", "$timestamp"}, {"unit", r.Unit}}}}},
+		}},
+		{"min", bson.D{{"$min", "$temperature"}}},
+		{"max", bson.D{{"$max", "$temperature"}}},
+		{"avg", bson.D{{"$avg", "$temperature"}}},
+		{"count", bson.D{{"$sum", 1}}},
+	}}}
+	// The _id of each summary identifies its sensor and window, so
+	// replacing matching documents makes the job idempotent
+	mergeStage := bson.D{{"$merge", bson.D{
+		{"into", r.Target},
+		{"on", "_id"},
+		{"whenMatched", "replace"},
+		{"whenNotMatched", "insert"},
+	}}}
+	cursor, err := j.source.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage, mergeStage})
+	if err != nil {
+		return err
+	}
+	if err := cursor.Close(ctx); err != nil {
+		return err
+	}
+
+	// Save the checkpoint only after $merge succeeds. If the job stops
+	// before this point, the next run summarizes the same windows again.
+	update := bson.D{
+		{"$max", bson.D{{"completedThrough", end}}},
+		{"$set", bson.D{{"updatedAt", time.Now()}}},
+	}
+	opts := options.Update().SetUpsert(true)
+	_, err = j.checkpoints.UpdateOne(ctx, bson.D{{"_id", r.Name}}, update, opts)
+	return err
+}
+
+// end run rollup
+
+func main() {
+	dbName := flag.String("db", "spring_weather", "the database that contains the readings")
+	collName := flag.String("coll", "sensor_readings", "the time series collection of raw readings")
+	lookback := flag.Int("lookback", 1, "the number of completed windows to summarize again for late readings")
+	check := flag.Bool("check", false, "roll up generated readings in the rollup_check database and verify the results, instead of running the job")
+	flag.Parse()
+
+	// $dateTrunc and $merge from a time series collection require
+	// MongoDB 5.0 or later
+	var uri string
+	if uri = os.Getenv("MONGODB_URI"); uri == "" {
+		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/")
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err = client.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+
+	if *check {
+		checkJob(client.Database("rollup_check"))
+		return
+	}
+
+	// begin create job
+	db := client.Database(*dbName)
+	j := &job{
+		source:      db.Collection(*collName),
+		checkpoints: db.Collection(*collName + "_rollup_checkpoints"),
+		rollups: []rollup{
+			{Name: "hourly", Unit: "hour", Target: *collName + "_hourly"},
+			{Name: "daily", Unit: "day", Target: *collName + "_daily"},
+		},
+		lookback: *lookback,
+		now:      time.Now,
+	}
+	if err := j.run(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+	// end create job
+	fmt.Println("Rolled up every closed window")
+}
+
+// reading is a generated measurement for checkJob.
+type reading struct {
+	Timestamp   time.Time         `bson:"timestamp"`
+	Sensor      map[string]string `bson:"sensor"`
+	Temperature float64           `bson:"temperature"`
+}
+
+// checkJob runs the job on generated readings and stops the program if a
+// summary differs from the value calculated from the raw readings.
+func checkJob(db *mongo.Database) {
+	if err := db.Drop(context.TODO()); err != nil {
+		panic(err)
+	}
+	tso := options.TimeSeries().SetTimeField("timestamp").SetMetaField("sensor").SetGranularity("minutes")
+	if err := db.CreateCollection(context.TODO(), "readings", options.CreateCollection().SetTimeSeriesOptions(tso)); err != nil {
+		panic(err)
+	}
+
+	now := time.Now().UTC()
+	j := &job{
+		source:      db.Collection("readings"),
+		checkpoints: db.Collection("checkpoints"),
+		rollups: []rollup{
+			{Name: "hourly", Unit: "hour", Target: "hourly"},
+			{Name: "daily", Unit: "day", Target: "daily"},
+		},
+		now: func() time.Time { return now },
+	}
+
+	// Generate a reading every 10 minutes for the last 50 hours
+	random := rand.New(rand.NewSource(1))
+	var readings []reading
+	var docs []interface{}
+	for t := now.Add(-50 * time.Hour); t.Before(now); t = t.Add(10 * time.Minute) {
+		for _, sensor := range []string{"s-100", "s-200"} {
+			r := reading{t, map[string]string{"sensorId": sensor}, math.Round(random.Float64()*3000) / 100}
+			readings = append(readings, r)
+			docs = append(docs, r)
+		}
+	}
+	if _, err := j.source.InsertMany(context.TODO(), docs); err != nil {
+		panic(err)
+	}
+
+	verify := func(label string) {
+		for _, r := range j.rollups {
+			verifyRollup(db.Collection(r.Target), readings, r.Unit, windowStart(now, r.Unit))
+		}
+		fmt.Printf("%s: every summary matches the raw readings\n", label)
+	}
+
+	if err := j.run(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+	verify("first run")
+
+	// Running the job again for the same time changes nothing
+	if err := j.run(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+	verify("second run")
+
+	// A late reading arrives for the last closed hour. Without lookback
+	// the job skips it, and with lookback it includes it.
+	late := reading{windowStart(now, "hour").Add(-time.Minute), map[string]string{"sensorId": "s-100"}, 99}
+	if _, err := j.source.InsertOne(context.TODO(), late); err != nil {
+		panic(err)
+	}
+	if err := j.run(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+	verify("late reading without lookback")
+
+	readings = append(readings, late)
+	j.lookback = 1
+	if err := j.run(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+	verify("late reading with lookback")
+
+	var cp checkpoint
+	if err := j.checkpoints.FindOne(context.TODO(), bson.D{{"_id", "hourly"}}).Decode(&cp); err != nil {
+		panic(err)
+	}
+	if !cp.CompletedThrough.Equal(windowStart(now, "hour")) {
+		log.Fatalf("the hourly checkpoint is %v, want %v", cp.CompletedThrough, windowStart(now, "hour"))
+	}
+	fmt.Printf("hourly checkpoint: %s\n", cp.CompletedThrough.Format(time.RFC3339))
+}
+
+func verifyRollup(target *mongo.Collection, readings []reading, unit string, end time.Time) {
+	type key struct {
+		sensor string
+		window time.Time
+	}
+	want := make(map[key]*summary)
+	for _, r := range readings {
+		if !r.Timestamp.Before(end) {
+			continue
+		}
+		k := key{r.Sensor["sensorId"], windowStart(r.Timestamp, unit)}
+		s, ok := want[k]
+		if !ok {
+			s = &summary{Min: r.Temperature, Max: r.Temperature}
+			want[k] = s
+		}
+		s.Min = math.Min(s.Min, r.Temperature)
+		s.Max = math.Max(s.Max, r.Temperature)
+		// Avg holds the sum until all readings are counted
+		s.Avg += r.Temperature
+		s.Count++
+	}
+
+	cursor, err := target.Find(context.TODO(), bson.D{})
+	if err != nil {
+		panic(err)
+	}
+	var got []summary
+	if err := cursor.All(context.TODO(), &got); err != nil {
+		panic(err)
+	}
+	if len(got) != len(want) {
+		log.Fatalf("%s: %d summaries, want %d", target.Name(), len(got), len(want))
+	}
+	for _, g := range got {
+		w, ok := want[key{g.ID.SensorID, g.ID.Window.UTC()}]
+		if !ok {
+			log.Fatalf("%s: unexpected summary for %s at %v", target.Name(), g.ID.SensorID, g.ID.Window)
+		}
+		avg := w.Avg / float64(w.Count)
+		if g.Count != w.Count || g.Min != w.Min || g.Max != w.Max || math.Abs(g.Avg-avg) > 1e-9 {
+			log.Fatalf("%s: %s at %v is {min %v max %v avg %v count %d}, want {min %v max %v avg %v count %d}",
+				target.Name(), g.ID.SensorID, g.ID.Window, g.Min, g.Max, g.Avg, g.Count, w.Min, w.Max, avg, w.Count)
+		}
+	}
+}
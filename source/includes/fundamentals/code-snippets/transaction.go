@@ -50,35 +50,35 @@ func main() {
 
 	fmt.Printf("Inserted _id values: %v\n", result)
 
-	// MANUAL TRANSACTION EXAMPLE
-	// uncomment this section to run this code
+	// begin manual transaction
+	// To control each step of the transaction, start, commit, and abort it
+	// yourself. This runs the transaction once. See transactionRetry.go for
+	// how to retry it when it fails with a temporary error.
+	err = mongo.WithSession(context.TODO(), session, func(ctx mongo.SessionContext) error {
+		if err := session.StartTransaction(txnOptions); err != nil {
+			return err
+		}
 
-	// err = mongo.WithSession(context.TODO(), session, func(ctx mongo.SessionContext) error {
-	// 	if err = session.StartTransaction(txnOptions); err != nil {
-	// 		return err
-	// 	}
+		docs := []interface{}{
+			bson.D{{"title", "The Year of Magical Thinking"}, {"author", "Joan Didion"}},
+			bson.D{{"title", "Play It As It Lays"}, {"author", "Joan Didion"}},
+			bson.D{{"title", "The White Album"}, {"author", "Joan Didion"}},
+		}
+		result, err := coll.InsertMany(ctx, docs)
+		if err != nil {
+			session.AbortTransaction(context.Background())
+			return err
+		}
 
-	// 	docs := []interface{}{
-	// 		bson.D{{"title", "The Year of Magical Thinking"}, {"author", "Joan Didion"}},
-	// 		bson.D{{"title", "Play It As It Lays"}, {"author", "Joan Didion"}},
-	// 		bson.D{{"title", "The White Album"}, {"author", "Joan Didion"}},
-	// 	}
-	// 	result, err := coll.InsertMany(ctx, docs)
-	// 	if err != nil {
-	// 		return err
-	// 	}
+		if err := session.CommitTransaction(ctx); err != nil {
+			return err
+		}
 
-	// 	if err = session.CommitTransaction(ctx); err != nil {
-	// 		return err
-	// 	}
-
-	// 	fmt.Println(result.InsertedIDs)
-	// 	return nil
-	// })
-	// if err != nil {
-	// 	if err := session.AbortTransaction(context.TODO()); err != nil {
-	// 		panic(err)
-	// 	}
-	// 	panic(err)
-	// }
+		fmt.Printf("Inserted _id values: %v\n", result.InsertedIDs)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	// end manual transaction
 }
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+const appName = "transactionRetry"
+
+// transactionTimeout matches the time limit that WithTransaction uses.
+const transactionTimeout = 120 * time.Second
+
+// begin has error label
+// hasErrorLabel reports whether err or an error that it wraps has the label.
+// CommandError, WriteException, and BulkWriteException all implement
+// mongo.LabeledError.
+func hasErrorLabel(err error, label string) bool {
+	var labeled mongo.LabeledError
+	return errors.As(err, &labeled) && labeled.HasErrorLabel(label)
+}
+
+// end has error label
+
+// begin manual retry
+// runTransactionWithRetry runs txnFn, which starts and commits a
+// transaction, and runs it again while it fails with an error labeled
+// TransientTransactionError.
+func runTransactionWithRetry(sctx mongo.SessionContext, txnFn func(mongo.SessionContext) error) error {
+	deadline := time.Now().Add(transactionTimeout)
+	for {
+		err := txnFn(sctx)
+		if err == nil {
+			return nil
+		}
+		// The whole transaction can run again, because the server aborted
+		// it and none of its writes are visible
+		if !hasErrorLabel(err, "TransientTransactionError") || time.Now().After(deadline) {
+			return err
+		}
+		log.Printf("  transaction failed with a transient error, retrying: %v", err)
+	}
+}
+
+// commitWithRetry commits the transaction, and commits it again while the
+// commit fails with an error labeled UnknownTransactionCommitResult.
+func commitWithRetry(sctx mongo.SessionContext) error {
+	deadline := time.Now().Add(transactionTimeout)
+	for {
+		err := sctx.CommitTransaction(sctx)
+		if err == nil {
+			return nil
+		}
+		// The transaction might have been committed, so only the commit
+		// can run again. Committing a committed transaction succeeds.
+		if !hasErrorLabel(err, "UnknownTransactionCommitResult") || time.Now().After(deadline) {
+			return err
+		}
+		// Like WithTransaction, stop if the commit ran out of time, because
+		// the time limit is the caller's decision to give up
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.IsMaxTimeMSExpiredError() {
+			return err
+		}
+		log.Printf("  commit result unknown, retrying the commit: %v", err)
+	}
+}
+
+// end manual retry
+
+// begin insert books
+// insertBooks returns a function that inserts the books in a transaction.
+func insertBooks(coll *mongo.Collection, docs []interface{}, txnOpts *options.TransactionOptions) func(mongo.SessionContext) error {
+	return func(sctx mongo.SessionContext) error {
+		if err := sctx.StartTransaction(txnOpts); err != nil {
+			return err
+		}
+		if _, err := coll.InsertMany(sctx, docs); err != nil {
+			// Abort uses a new context, so that it runs even if sctx has
+			// expired
+			sctx.AbortTransaction(context.Background())
+			return err
+		}
+		return commitWithRetry(sctx)
+	}
+}
+
+// end insert books
+
+// commandCounter counts the insert and commitTransaction commands that the
+// client sends.
+type commandCounter struct {
+	inserts, commits int64
+}
+
+func (c *commandCounter) monitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			switch e.CommandName {
+			case "insert":
+				atomic.AddInt64(&c.inserts, 1)
+			case "commitTransaction":
+				atomic.AddInt64(&c.commits, 1)
+			}
+		},
+	}
+}
+
+func (c *commandCounter) reset() {
+	atomic.StoreInt64(&c.inserts, 0)
+	atomic.StoreInt64(&c.commits, 0)
+}
+
+// begin fail point
+// setFailPoint makes the next two commands named command fail with the
+// error code and labels. The fail point requires a mongod or mongos that
+// was started with --setParameter enableTestCommands=1.
+func setFailPoint(client *mongo.Client, command string, code int32, label string) error {
+	cmd := bson.D{
+		{"configureFailPoint", "failCommand"},
+		{"mode", bson.D{{"times", 2}}},
+		{"data", bson.D{
+			{"failCommands", bson.A{command}},
+			{"errorCode", code},
+			{"errorLabels", bson.A{label}},
+			// Only fail the commands of this program
+			{"appName", appName},
+		}},
+	}
+	return client.Database("admin").RunCommand(context.TODO(), cmd).Err()
+}
+
+// end fail point
+
+func clearFailPoint(client *mongo.Client) error {
+	cmd := bson.D{{"configureFailPoint", "failCommand"}, {"mode", "off"}}
+	return client.Database("admin").RunCommand(context.TODO(), cmd).Err()
+}
+
+func main() {
+	// Transactions require a replica set or sharded cluster
+	var uri string
+	if uri = os.Getenv("MONGODB_URI"); uri == "" {
+		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/")
+	}
+
+	var counter commandCounter
+	clientOpts := options.Client().
+		ApplyURI(uri).
+		SetAppName(appName).
+		SetMonitor(counter.monitor())
+	client, err := mongo.Connect(context.TODO(), clientOpts)
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err = client.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+
+	if err := clearFailPoint(client); err != nil {
+		log.Fatalf("can't configure fail points, start mongod with --setParameter enableTestCommands=1: %v", err)
+	}
+	defer clearFailPoint(client)
+
+	coll := client.Database("myDB").Collection("myColl")
+	docs := []interface{}{
+		bson.D{{"title", "The Year of Magical Thinking"}, {"author", "Joan Didion"}},
+		bson.D{{"title", "Play It As It Lays"}, {"author", "Joan Didion"}},
+		bson.D{{"title", "The White Album"}, {"author", "Joan Didion"}},
+	}
+	txnOpts := options.Transaction().
+		SetReadConcern(readconcern.Snapshot()).
+		SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+
+	approaches := []struct {
+		name string
+		run  func(mongo.Session) error
+	}{
+		{
+			name: "manual retry",
+			run: func(session mongo.Session) error {
+				// begin run manual
+				return mongo.WithSession(context.TODO(), session, func(sctx mongo.SessionContext) error {
+					return runTransactionWithRetry(sctx, insertBooks(coll, docs, txnOpts))
+				})
+				// end run manual
+			},
+		},
+		{
+			name: "WithTransaction",
+			run: func(session mongo.Session) error {
+				_, err := session.WithTransaction(context.TODO(), func(sctx mongo.SessionContext) (interface{}, error) {
+					return coll.InsertMany(sctx, docs)
+				}, txnOpts)
+				return err
+			},
+		},
+	}
+
+	// WriteConflict and WriteConcernFailed aren't retryable write errors,
+	// so the driver doesn't retry the commands on its own
+	scenarios := []struct {
+		name          string
+		command       string
+		code          int32
+		label         string
+		wantInserts   int64
+		wantCommits   int64
+		wantDocuments int64
+	}{
+		{"no errors", "", 0, "", 1, 1, 3},
+		{"insert fails twice with TransientTransactionError", "insert", 112, "TransientTransactionError", 3, 1, 3},
+		{"commit fails twice with UnknownTransactionCommitResult", "commitTransaction", 64, "UnknownTransactionCommitResult", 1, 3, 3},
+	}
+
+	for _, scenario := range scenarios {
+		fmt.Printf("%s:\n", scenario.name)
+		for _, approach := range approaches {
+			if err := coll.Drop(context.TODO()); err != nil {
+				panic(err)
+			}
+			// Create the collection outside the transaction
+			if err := client.Database("myDB").CreateCollection(context.TODO(), "myColl"); err != nil {
+				panic(err)
+			}
+			if scenario.command != "" {
+				if err := setFailPoint(client, scenario.command, scenario.code, scenario.label); err != nil {
+					panic(err)
+				}
+			}
+			counter.reset()
+
+			session, err := client.StartSession()
+			if err != nil {
+				panic(err)
+			}
+			err = approach.run(session)
+			session.EndSession(context.TODO())
+			if err := clearFailPoint(client); err != nil {
+				panic(err)
+			}
+			if err != nil {
+				log.Fatalf("%s: %v", approach.name, err)
+			}
+
+			count, err := coll.CountDocuments(context.TODO(), bson.D{})
+			if err != nil {
+				panic(err)
+			}
+			inserts, commits := atomic.LoadInt64(&counter.inserts), atomic.LoadInt64(&counter.commits)
+			fmt.Printf("  %-16s %d insert attempt(s), %d commit attempt(s), %d document(s)\n",
+				approach.name+":", inserts, commits, count)
+			if inserts != scenario.wantInserts || commits != scenario.wantCommits || count != scenario.wantDocuments {
+				log.Fatalf("%s: want %d insert attempt(s), %d commit attempt(s), and %d document(s)",
+					approach.name, scenario.wantInserts, scenario.wantCommits, scenario.wantDocuments)
+			}
+		}
+	}
+	fmt.Println("\nThe manual retry loop and WithTransaction retried the same operations")
+}
@@ -0,0 +1,158 @@
+// Package update builds update documents from typed operator constructors.
+//
+// Each constructor returns an Op that changes one field. New groups the
+// ops by operator into an update document:
+//
+//	u, err := update.New(
+//		update.Set("species", "Ledebouria socialis"),
+//		update.Inc(update.Positional("sizes"), -2),
+//	)
+//
+// The result is a bson.D value that you can pass to any method that
+// accepts an update document.
+package update
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Op is an update operator applied to one field.
+type Op struct {
+	Operator string
+	Field    string
+	Value    interface{}
+}
+
+// New returns an update document that applies every op. Ops with the same
+// operator share one operator document, in the order that they first
+// appear. New returns an error if two ops change the same field, because
+// the server rejects an update that changes a field twice.
+func New(ops ...Op) (bson.D, error) {
+	var doc bson.D
+	index := make(map[string]int)
+	changed := make(map[string]string)
+	for _, op := range ops {
+		if previous, ok := changed[op.Field]; ok {
+			return nil, fmt.Errorf("update: %s and %s both change %q", previous, op.Operator, op.Field)
+		}
+		changed[op.Field] = op.Operator
+
+		i, ok := index[op.Operator]
+		if !ok {
+			i = len(doc)
+			index[op.Operator] = i
+			doc = append(doc, bson.E{op.Operator, bson.D{}})
+		}
+		doc[i].Value = append(doc[i].Value.(bson.D), bson.E{op.Field, op.Value})
+	}
+	return doc, nil
+}
+
+// Positional returns the path that updates the first array element that
+// the filter matched, such as "sizes.$".
+func Positional(field string) string {
+	return field + ".$"
+}
+
+// AllPositional returns the path that updates every array element, such as
+// "sizes.$[]".
+func AllPositional(field string) string {
+	return field + ".$[]"
+}
+
+// FilteredPositional returns the path that updates the array elements that
+// match the array filter for identifier, such as "styles.$[hot]".
+func FilteredPositional(field, identifier string) string {
+	return field + ".$[" + identifier + "]"
+}
+
+// Set sets field to value.
+func Set(field string, value interface{}) Op {
+	return Op{"$set", field, value}
+}
+
+// SetOnInsert sets field to value only if an upsert inserts the document.
+func SetOnInsert(field string, value interface{}) Op {
+	return Op{"$setOnInsert", field, value}
+}
+
+// Unset removes field.
+func Unset(field string) Op {
+	return Op{"$unset", field, ""}
+}
+
+// Rename renames field to newName.
+func Rename(field, newName string) Op {
+	return Op{"$rename", field, newName}
+}
+
+// Inc adds amount to field. A negative amount subtracts.
+func Inc(field string, amount interface{}) Op {
+	return Op{"$inc", field, amount}
+}
+
+// Mul multiplies field by factor.
+func Mul(field string, factor interface{}) Op {
+	return Op{"$mul", field, factor}
+}
+
+// Min sets field to value if value is less than the current value.
+func Min(field string, value interface{}) Op {
+	return Op{"$min", field, value}
+}
+
+// Max sets field to value if value is greater than the current value.
+func Max(field string, value interface{}) Op {
+	return Op{"$max", field, value}
+}
+
+// CurrentDate sets field to the current date.
+func CurrentDate(field string) Op {
+	return Op{"$currentDate", field, true}
+}
+
+// Push appends value to the array in field.
+func Push(field string, value interface{}) Op {
+	return Op{"$push", field, value}
+}
+
+// PushEach appends every one of values to the array in field.
+func PushEach(field string, values ...interface{}) Op {
+	return Op{"$push", field, bson.D{{"$each", bson.A(values)}}}
+}
+
+// AddToSet appends value to the array in field unless the array already
+// contains it.
+func AddToSet(field string, value interface{}) Op {
+	return Op{"$addToSet", field, value}
+}
+
+// AddToSetEach appends each of values that the array in field doesn't
+// already contain.
+func AddToSetEach(field string, values ...interface{}) Op {
+	return Op{"$addToSet", field, bson.D{{"$each", bson.A(values)}}}
+}
+
+// Pull removes the elements of the array in field that equal value. Pass
+// a bson.D of conditions to remove the elements that match them.
+func Pull(field string, value interface{}) Op {
+	return Op{"$pull", field, value}
+}
+
+// PullAll removes every element of the array in field that equals any of
+// values.
+func PullAll(field string, values ...interface{}) Op {
+	return Op{"$pullAll", field, bson.A(values)}
+}
+
+// PopFirst removes the first element of the array in field.
+func PopFirst(field string) Op {
+	return Op{"$pop", field, -1}
+}
+
+// PopLast removes the last element of the array in field.
+func PopLast(field string) Op {
+	return Op{"$pop", field, 1}
+}
@@ -0,0 +1,111 @@
+package update
+
+import (
+	"bytes"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"includes/fundamentals/code-snippets/filter"
+)
+
+// marshal returns the BSON bytes of doc.
+func marshal(t *testing.T, doc bson.D) []byte {
+	t.Helper()
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// mustNew returns the update document of ops, and fails the test if New
+// returns an error.
+func mustNew(t *testing.T, ops ...Op) bson.D {
+	t.Helper()
+	u, err := New(ops...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// TestNew compares the updates that the CRUD guides and usage examples
+// write as literals, and one update for each other operator, with the
+// documents that New returns.
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder bson.D
+		want    bson.D
+	}{
+		{"$set", mustNew(t, Set("avg_rating", 4.4)),
+			bson.D{{"$set", bson.D{{"avg_rating", 4.4}}}}},
+		{"$set with several fields", mustNew(t, Set("species", "Ledebouria socialis"), Set("plant_id", 3), Set("height", 8.3)),
+			bson.D{{"$set", bson.D{{"species", "Ledebouria socialis"}, {"plant_id", 3}, {"height", 8.3}}}}},
+		{"$set an array", mustNew(t, Set("styles", bson.A{"iced", "hot", "extra hot"})),
+			bson.D{{"$set", bson.D{{"styles", bson.A{"iced", "hot", "extra hot"}}}}}},
+		{"$setOnInsert", mustNew(t, SetOnInsert("created", "today")),
+			bson.D{{"$setOnInsert", bson.D{{"created", "today"}}}}},
+		{"several operators", mustNew(t, Set("status", "shipped"), Inc("quantity", 1), Set("rating", 8)),
+			bson.D{{"$set", bson.D{{"status", "shipped"}, {"rating", 8}}}, {"$inc", bson.D{{"quantity", 1}}}}},
+		{"$inc", mustNew(t, Inc("rating", 2)),
+			bson.D{{"$inc", bson.D{{"rating", 2}}}}},
+		{"$inc with $", mustNew(t, Inc(Positional("sizes"), -2)),
+			bson.D{{"$inc", bson.D{{"sizes.$", -2}}}}},
+		{"$mul", mustNew(t, Mul("price", 1.15)),
+			bson.D{{"$mul", bson.D{{"price", 1.15}}}}},
+		{"$mul with $[]", mustNew(t, Mul(AllPositional("sizes"), 29.57)),
+			bson.D{{"$mul", bson.D{{"sizes.$[]", 29.57}}}}},
+		{"$unset with $[<identifier>]", mustNew(t, Unset(FilteredPositional("styles", "hotOptions"))),
+			bson.D{{"$unset", bson.D{{"styles.$[hotOptions]", ""}}}}},
+		{"$rename", mustNew(t, Rename("avg_rating", "rating")),
+			bson.D{{"$rename", bson.D{{"avg_rating", "rating"}}}}},
+		{"$min and $max", mustNew(t, Min("low", 3), Max("high", 9)),
+			bson.D{{"$min", bson.D{{"low", 3}}}, {"$max", bson.D{{"high", 9}}}}},
+		{"$currentDate", mustNew(t, CurrentDate("updated")),
+			bson.D{{"$currentDate", bson.D{{"updated", true}}}}},
+		{"$push", mustNew(t, Push("vendor", "D")),
+			bson.D{{"$push", bson.D{{"vendor", "D"}}}}},
+		{"$push with $each", mustNew(t, PushEach("vendor", "D", "E")),
+			bson.D{{"$push", bson.D{{"vendor", bson.D{{"$each", bson.A{"D", "E"}}}}}}}},
+		{"$addToSet", mustNew(t, AddToSet("styles", "iced")),
+			bson.D{{"$addToSet", bson.D{{"styles", "iced"}}}}},
+		{"$addToSet with $each", mustNew(t, AddToSetEach("styles", "iced", "warm")),
+			bson.D{{"$addToSet", bson.D{{"styles", bson.D{{"$each", bson.A{"iced", "warm"}}}}}}}},
+		{"$pull", mustNew(t, Pull("sizes", bson.D{bson.E(filter.Gt(0))})),
+			bson.D{{"$pull", bson.D{{"sizes", bson.D{{"$gt", 0}}}}}}},
+		{"$pull a value", mustNew(t, Pull("styles", "hot")),
+			bson.D{{"$pull", bson.D{{"styles", "hot"}}}}},
+		{"$pullAll", mustNew(t, PullAll("styles", "hot", "extra hot")),
+			bson.D{{"$pullAll", bson.D{{"styles", bson.A{"hot", "extra hot"}}}}}},
+		{"$pop", mustNew(t, PopFirst("sizes"), PopLast("styles")),
+			bson.D{{"$pop", bson.D{{"sizes", -1}, {"styles", 1}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !bytes.Equal(marshal(t, tt.builder), marshal(t, tt.want)) {
+				t.Errorf("got %v, want %v", tt.builder, tt.want)
+			}
+		})
+	}
+}
+
+// TestNewDuplicateField checks that New rejects two ops that change the
+// same field.
+func TestNewDuplicateField(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  []Op
+	}{
+		{"same operator", []Op{Set("status", "shipped"), Set("status", "delivered")}},
+		{"different operators", []Op{Set("quantity", 0), Inc("quantity", 1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if u, err := New(tt.ops...); err == nil {
+				t.Errorf("New returned %v, want an error", u)
+			}
+		})
+	}
+}
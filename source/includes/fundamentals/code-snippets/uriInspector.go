@@ -0,0 +1,586 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+)
+
+// deprecatedOptions maps legacy URI option names, in lowercase, to the
+// options that replace them.
+var deprecatedOptions = map[string]string{
+	"ssl":                             "tls",
+	"sslclientcertificatekeyfile":     "tlsCertificateKeyFile",
+	"sslclientcertificatekeypassword": "tlsCertificateKeyFilePassword",
+	"sslinsecure":                     "tlsInsecure",
+	"sslcertificateauthorityfile":     "tlsCAFile",
+	"connect":                         "directConnection",
+	"heartbeatintervalms":             "heartbeatFrequencyMS",
+	"maxstaleness":                    "maxStalenessSeconds",
+	"wtimeout":                        "wtimeoutMS",
+}
+
+func main() {
+	uri := flag.String("uri", os.Getenv("MONGODB_URI"), "the connection string to inspect, defaults to $MONGODB_URI")
+	emitGo := flag.Bool("go", false, "print the equivalent ClientOptions setter chain")
+	flag.Parse()
+
+	if *uri == "" {
+		log.Fatal("You must pass a connection string with -uri or set your 'MONGODB_URI' environmental variable.")
+	}
+
+	fmt.Printf("URI: %s\n\n", redactURI(*uri))
+
+	// begin parse uri
+	// ApplyURI records parsing errors instead of returning them. Call
+	// Validate to retrieve the first error.
+	clientOpts := options.Client().ApplyURI(*uri)
+	if err := clientOpts.Validate(); err != nil {
+		log.Fatalf("invalid connection string: %v", err)
+	}
+	// end parse uri
+
+	// Parse the URI again to find out which options the user specified
+	cs, err := connstring.Parse(*uri)
+	if err != nil {
+		log.Fatalf("invalid connection string: %v", err)
+	}
+
+	printOptions(clientOpts, cs)
+
+	if warnings := checkOptions(clientOpts, cs); len(warnings) > 0 {
+		fmt.Println("\nWarnings:")
+		for _, warning := range warnings {
+			fmt.Printf("  - %s\n", warning)
+		}
+	}
+
+	if *emitGo {
+		fmt.Println("\nEquivalent Go code:")
+		fmt.Println(goCode(clientOpts, cs))
+	}
+}
+
+// secretOptions are the URI options, in lowercase, whose values redactURI
+// replaces with asterisks.
+var secretOptions = map[string]bool{
+	"tlscertificatekeyfilepassword":   true,
+	"sslclientcertificatekeypassword": true,
+}
+
+// redactURI replaces the password, the values of authMechanismProperties,
+// and the values of secretOptions in a connection string with asterisks.
+func redactURI(uri string) string {
+	schemeEnd := strings.Index(uri, "://")
+	if schemeEnd < 0 {
+		return uri
+	}
+	rest := uri[schemeEnd+3:]
+	hostsEnd := strings.IndexAny(rest, "/?")
+	if hostsEnd < 0 {
+		hostsEnd = len(rest)
+	}
+	if at := strings.LastIndex(rest[:hostsEnd], "@"); at >= 0 {
+		userInfo := rest[:at]
+		if colon := strings.Index(userInfo, ":"); colon >= 0 {
+			userInfo = userInfo[:colon] + ":****"
+		}
+		rest = userInfo + rest[at:]
+	}
+
+	question := strings.Index(rest, "?")
+	if question < 0 {
+		return uri[:schemeEnd+3] + rest
+	}
+	var b strings.Builder
+	b.WriteString(uri[:schemeEnd+3] + rest[:question+1])
+	// The driver accepts both & and ; between options
+	for query := rest[question+1:]; query != ""; {
+		end := strings.IndexAny(query, "&;")
+		if end < 0 {
+			end = len(query)
+		}
+		b.WriteString(redactOption(query[:end]))
+		if end < len(query) {
+			b.WriteByte(query[end])
+			end++
+		}
+		query = query[end:]
+	}
+	return b.String()
+}
+
+// redactOption replaces the value of a key=value URI option with asterisks
+// if the value is secret. It keeps the names of authMechanismProperties.
+func redactOption(option string) string {
+	eq := strings.Index(option, "=")
+	if eq < 0 {
+		return option
+	}
+	key, value := option[:eq], option[eq+1:]
+	switch {
+	case secretOptions[strings.ToLower(key)]:
+		return key + "=****"
+	case strings.EqualFold(key, "authMechanismProperties"):
+		props := strings.Split(value, ",")
+		for i, prop := range props {
+			// The colon after the name may be percent-encoded
+			if colon := strings.Index(prop, ":"); colon >= 0 {
+				props[i] = prop[:colon] + ":****"
+			} else if colon := strings.Index(strings.ToUpper(prop), "%3A"); colon >= 0 {
+				props[i] = prop[:colon] + "%3A****"
+			} else {
+				props[i] = "****"
+			}
+		}
+		return key + "=" + strings.Join(props, ",")
+	}
+	return option
+}
+
+func printOptions(opts *options.ClientOptions, cs connstring.ConnString) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "OPTION\tVALUE\tDEFAULT")
+	row := func(name string, value interface{}, def string) {
+		fmt.Fprintf(w, "%s\t%v\t%s\n", name, value, def)
+	}
+
+	row("scheme", cs.Scheme, "")
+	row("hosts", strings.Join(opts.Hosts, ","), "")
+	row("appName", stringValue(opts.AppName), "none")
+	row("replicaSet", stringValue(opts.ReplicaSet), "none")
+	row("srvMaxHosts", intValue(opts.SRVMaxHosts), "0 (no limit)")
+	row("srvServiceName", stringValue(opts.SRVServiceName), "mongodb")
+	row("directConnection", boolValue(opts.Direct), "false")
+	row("loadBalanced", boolValue(opts.LoadBalanced), "false")
+
+	if opts.Auth != nil {
+		password := ""
+		if opts.Auth.PasswordSet || opts.Auth.Password != "" {
+			password = "****"
+		}
+		row("authMechanism", opts.Auth.AuthMechanism, "negotiated (SCRAM-SHA-256 or SCRAM-SHA-1)")
+		row("authSource", opts.Auth.AuthSource, "admin, or $external for X.509, AWS and GSSAPI")
+		row("username", opts.Auth.Username, "")
+		row("password", password, "")
+		for key := range opts.Auth.AuthMechanismProperties {
+			row("authMechanismProperties."+key, "****", "")
+		}
+	} else {
+		row("credentials", "", "none")
+	}
+
+	row("tls", opts.TLSConfig != nil, "false, or true for mongodb+srv")
+	if opts.TLSConfig != nil {
+		row("tlsInsecure", opts.TLSConfig.InsecureSkipVerify, "false")
+		row("tlsCAFile", cs.SSLCaFile, "system roots")
+		row("tlsCertificateKeyFile", cs.SSLClientCertificateKeyFile, "none")
+	}
+	row("tlsDisableOCSPEndpointCheck", boolValue(opts.DisableOCSPEndpointCheck), "false")
+
+	row("timeoutMS", durationValue(opts.Timeout), "none")
+	row("connectTimeoutMS", durationValue(opts.ConnectTimeout), "30s")
+	row("socketTimeoutMS", durationValue(opts.SocketTimeout), "none")
+	row("serverSelectionTimeoutMS", durationValue(opts.ServerSelectionTimeout), "30s")
+	row("heartbeatFrequencyMS", durationValue(opts.HeartbeatInterval), "10s")
+	row("localThresholdMS", durationValue(opts.LocalThreshold), "15ms")
+	row("maxIdleTimeMS", durationValue(opts.MaxConnIdleTime), "none")
+	row("maxPoolSize", uintValue(opts.MaxPoolSize), "100")
+	row("minPoolSize", uintValue(opts.MinPoolSize), "0")
+	row("maxConnecting", uintValue(opts.MaxConnecting), "2")
+
+	row("compressors", strings.Join(opts.Compressors, ","), "none")
+	row("zlibCompressionLevel", intValue(opts.ZlibLevel), "6")
+	row("zstdCompressionLevel", intValue(opts.ZstdLevel), "6")
+
+	row("retryReads", boolValue(opts.RetryReads), "true")
+	row("retryWrites", boolValue(opts.RetryWrites), "true")
+
+	readPref := ""
+	if opts.ReadPreference != nil {
+		readPref = opts.ReadPreference.String()
+	}
+	row("readPreference", readPref, "primary")
+
+	readConcern := ""
+	if opts.ReadConcern != nil {
+		readConcern = opts.ReadConcern.GetLevel()
+	}
+	row("readConcernLevel", readConcern, "server default")
+
+	if opts.WriteConcern != nil {
+		row("w", opts.WriteConcern.GetW(), "server default")
+		row("journal", opts.WriteConcern.GetJ(), "server default")
+		row("wtimeoutMS", opts.WriteConcern.GetWTimeout(), "none")
+	} else {
+		row("writeConcern", "", "server default")
+	}
+}
+
+// checkOptions reports options that the driver ignores, options that are
+// deprecated, and combinations of options that conflict.
+func checkOptions(opts *options.ClientOptions, cs connstring.ConnString) []string {
+	var warnings []string
+
+	var unknown []string
+	for key := range cs.UnknownOptions {
+		unknown = append(unknown, key)
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		warnings = append(warnings, fmt.Sprintf("%q is not recognized by this driver version and is ignored", key))
+	}
+
+	for key := range cs.Options {
+		if replacement, ok := deprecatedOptions[key]; ok {
+			warnings = append(warnings, fmt.Sprintf("%q is deprecated, use %q instead", key, replacement))
+		}
+	}
+
+	if opts.TLSConfig == nil && (cs.SSLCaFileSet || cs.SSLClientCertificateKeyFileSet || cs.SSLInsecureSet) {
+		warnings = append(warnings, "TLS options are set but TLS is disabled, so the driver ignores them")
+	}
+	if opts.Timeout != nil && (opts.SocketTimeout != nil || (opts.WriteConcern != nil && opts.WriteConcern.GetWTimeout() != 0)) {
+		warnings = append(warnings, "timeoutMS is set, so socketTimeoutMS and wtimeoutMS may result in undefined behavior")
+	} else if opts.SocketTimeout != nil {
+		warnings = append(warnings, "socketTimeoutMS will be deprecated, consider using timeoutMS instead")
+	}
+	if opts.Direct != nil && *opts.Direct && opts.ReplicaSet != nil {
+		warnings = append(warnings, "directConnection=true connects to a single host, so replicaSet is not used for discovery")
+	}
+	if opts.Auth != nil && opts.Auth.Username == "" && opts.Auth.AuthSource != "" &&
+		opts.Auth.AuthMechanism != "MONGODB-X509" && opts.Auth.AuthMechanism != "MONGODB-AWS" {
+		warnings = append(warnings, "authSource is set but the connection string has no username")
+	}
+
+	sort.Strings(warnings)
+	return warnings
+}
+
+// goCode returns the setter chain that configures the same options as the
+// connection string, after the statements that build the values that the
+// setters need.
+func goCode(opts *options.ClientOptions, cs connstring.ConnString) string {
+	var setup []string
+	lines := []string{"clientOpts := options.Client()."}
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, "\t"+fmt.Sprintf(format, args...)+".")
+	}
+
+	// A mongodb+srv URI can also receive replicaSet, authSource and
+	// loadBalanced from a DNS TXT record, which ApplyURI reads again
+	srv := cs.Scheme == connstring.SchemeMongoDBSRV
+	inURI := uriOptions(cs.Original)
+	fromURI := func(key string) bool {
+		return !srv || inURI[key]
+	}
+
+	if srv {
+		// Keep the SRV hostname, because ApplyURI resolves it and the
+		// driver polls the SRV record for changes to the hosts
+		add("ApplyURI(%q)", "mongodb+srv://"+srvHostname(cs.Original)+"/")
+	} else {
+		hosts := make([]string, len(opts.Hosts))
+		for i, host := range opts.Hosts {
+			hosts[i] = fmt.Sprintf("%q", host)
+		}
+		add("SetHosts([]string{%s})", strings.Join(hosts, ", "))
+	}
+
+	if opts.AppName != nil {
+		add("SetAppName(%q)", *opts.AppName)
+	}
+	if opts.ReplicaSet != nil && fromURI("replicaset") {
+		add("SetReplicaSet(%q)", *opts.ReplicaSet)
+	}
+	if opts.SRVMaxHosts != nil {
+		add("SetSRVMaxHosts(%d)", *opts.SRVMaxHosts)
+	}
+	if opts.SRVServiceName != nil {
+		add("SetSRVServiceName(%q)", *opts.SRVServiceName)
+	}
+	if opts.Direct != nil {
+		add("SetDirect(%t)", *opts.Direct)
+	}
+	if opts.LoadBalanced != nil && fromURI("loadbalanced") {
+		add("SetLoadBalanced(%t)", *opts.LoadBalanced)
+	}
+	if opts.Auth != nil && (opts.Auth.Username != "" || opts.Auth.AuthMechanism != "" || fromURI("authsource")) {
+		fields := []string{}
+		if opts.Auth.AuthMechanism != "" {
+			fields = append(fields, fmt.Sprintf("AuthMechanism: %q", opts.Auth.AuthMechanism))
+		}
+		if len(opts.Auth.AuthMechanismProperties) > 0 {
+			keys := make([]string, 0, len(opts.Auth.AuthMechanismProperties))
+			for key := range opts.Auth.AuthMechanismProperties {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			props := make([]string, len(keys))
+			for i, key := range keys {
+				value := fmt.Sprintf("%q", opts.Auth.AuthMechanismProperties[key])
+				if strings.EqualFold(key, "AWS_SESSION_TOKEN") {
+					value = `"<session token>"`
+				}
+				props[i] = fmt.Sprintf("%q: %s", key, value)
+			}
+			fields = append(fields, fmt.Sprintf("AuthMechanismProperties: map[string]string{%s}", strings.Join(props, ", ")))
+		}
+		// SetAuth replaces the credential that ApplyURI builds, so it
+		// also sets an authSource from a TXT record
+		if opts.Auth.AuthSource != "" {
+			fields = append(fields, fmt.Sprintf("AuthSource: %q", opts.Auth.AuthSource))
+		}
+		if opts.Auth.Username != "" {
+			fields = append(fields, fmt.Sprintf("Username: %q", opts.Auth.Username))
+		}
+		if opts.Auth.PasswordSet || opts.Auth.Password != "" {
+			fields = append(fields, `Password: "<password>"`)
+		}
+		add("SetAuth(options.Credential{%s})", strings.Join(fields, ", "))
+	}
+	// ApplyURI enables TLS for a mongodb+srv URI, so a TLS configuration
+	// is only needed for the TLS options in the URI
+	if opts.TLSConfig != nil && (!srv || cs.SSLCaFileSet || cs.SSLClientCertificateKeyFileSet ||
+		cs.SSLCertificateFileSet || cs.SSLInsecureSet) {
+		setup = append(setup, tlsSetup(cs)...)
+		add("SetTLSConfig(tlsConfig)")
+	}
+	if opts.DisableOCSPEndpointCheck != nil {
+		add("SetDisableOCSPEndpointCheck(%t)", *opts.DisableOCSPEndpointCheck)
+	}
+	if opts.Timeout != nil {
+		add("SetTimeout(%s)", goDuration(*opts.Timeout))
+	}
+	if opts.ConnectTimeout != nil {
+		add("SetConnectTimeout(%s)", goDuration(*opts.ConnectTimeout))
+	}
+	if opts.SocketTimeout != nil {
+		add("SetSocketTimeout(%s)", goDuration(*opts.SocketTimeout))
+	}
+	if opts.ServerSelectionTimeout != nil {
+		add("SetServerSelectionTimeout(%s)", goDuration(*opts.ServerSelectionTimeout))
+	}
+	if opts.HeartbeatInterval != nil {
+		add("SetHeartbeatInterval(%s)", goDuration(*opts.HeartbeatInterval))
+	}
+	if opts.LocalThreshold != nil {
+		add("SetLocalThreshold(%s)", goDuration(*opts.LocalThreshold))
+	}
+	if opts.MaxConnIdleTime != nil {
+		add("SetMaxConnIdleTime(%s)", goDuration(*opts.MaxConnIdleTime))
+	}
+	if opts.MaxPoolSize != nil {
+		add("SetMaxPoolSize(%d)", *opts.MaxPoolSize)
+	}
+	if opts.MinPoolSize != nil {
+		add("SetMinPoolSize(%d)", *opts.MinPoolSize)
+	}
+	if opts.MaxConnecting != nil {
+		add("SetMaxConnecting(%d)", *opts.MaxConnecting)
+	}
+	if len(opts.Compressors) > 0 {
+		compressors := make([]string, len(opts.Compressors))
+		for i, compressor := range opts.Compressors {
+			compressors[i] = fmt.Sprintf("%q", compressor)
+		}
+		add("SetCompressors([]string{%s})", strings.Join(compressors, ", "))
+	}
+	// ApplyURI sets default compression levels, so print only the levels
+	// that appear in the connection string
+	if cs.ZlibLevelSet {
+		add("SetZlibLevel(%d)", *opts.ZlibLevel)
+	}
+	if cs.ZstdLevelSet {
+		add("SetZstdLevel(%d)", *opts.ZstdLevel)
+	}
+	if opts.RetryReads != nil {
+		add("SetRetryReads(%t)", *opts.RetryReads)
+	}
+	if opts.RetryWrites != nil {
+		add("SetRetryWrites(%t)", *opts.RetryWrites)
+	}
+	if rp := opts.ReadPreference; rp != nil {
+		rpOpts := []string{"readpref." + exportedName(rp.Mode().String()) + "Mode"}
+		if tagSets := rp.TagSets(); len(tagSets) > 0 {
+			sets := make([]string, len(tagSets))
+			for i, set := range tagSets {
+				tags := make([]string, len(set))
+				for j, t := range set {
+					tags[j] = fmt.Sprintf("{Name: %q, Value: %q}", t.Name, t.Value)
+				}
+				sets[i] = "tag.Set{" + strings.Join(tags, ", ") + "}"
+			}
+			rpOpts = append(rpOpts, fmt.Sprintf("readpref.WithTagSets(%s)", strings.Join(sets, ", ")))
+		}
+		if maxStaleness, ok := rp.MaxStaleness(); ok {
+			rpOpts = append(rpOpts, fmt.Sprintf("readpref.WithMaxStaleness(%s)", goDuration(maxStaleness)))
+		}
+		setup = append(setup,
+			fmt.Sprintf("readPref, err := readpref.New(%s)", strings.Join(rpOpts, ", ")),
+			"if err != nil {",
+			"\tpanic(err)",
+			"}",
+		)
+		add("SetReadPreference(readPref)")
+	}
+	if opts.ReadConcern != nil {
+		add("SetReadConcern(readconcern.New(readconcern.Level(%q)))", opts.ReadConcern.GetLevel())
+	}
+	if opts.WriteConcern != nil {
+		wcOpts := []string{}
+		switch w := opts.WriteConcern.GetW().(type) {
+		case string:
+			if w == "majority" {
+				wcOpts = append(wcOpts, "writeconcern.WMajority()")
+			} else {
+				wcOpts = append(wcOpts, fmt.Sprintf("writeconcern.WTagSet(%q)", w))
+			}
+		case int:
+			wcOpts = append(wcOpts, fmt.Sprintf("writeconcern.W(%d)", w))
+		}
+		if opts.WriteConcern.GetJ() {
+			wcOpts = append(wcOpts, "writeconcern.J(true)")
+		}
+		if wtimeout := opts.WriteConcern.GetWTimeout(); wtimeout != 0 {
+			wcOpts = append(wcOpts, fmt.Sprintf("writeconcern.WTimeout(%s)", goDuration(wtimeout)))
+		}
+		add("SetWriteConcern(writeconcern.New(%s))", strings.Join(wcOpts, ", "))
+	}
+
+	// Remove the trailing dot from the last setter
+	last := len(lines) - 1
+	lines[last] = strings.TrimSuffix(lines[last], ".")
+	if len(setup) > 0 {
+		lines = append(append(setup, ""), lines...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// tlsSetup returns the statements that build tlsConfig from the TLS
+// options in the connection string.
+func tlsSetup(cs connstring.ConnString) []string {
+	code := []string{"tlsConfig := &tls.Config{}"}
+	if cs.SSLInsecure {
+		code = append(code, "tlsConfig.InsecureSkipVerify = true")
+	}
+	if cs.SSLCaFileSet {
+		code = append(code,
+			fmt.Sprintf("caPEM, err := os.ReadFile(%q)", cs.SSLCaFile),
+			"if err != nil {",
+			"\tpanic(err)",
+			"}",
+			"tlsConfig.RootCAs = x509.NewCertPool()",
+			"if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {",
+			fmt.Sprintf("\tpanic(%q)", "no certificates in "+cs.SSLCaFile),
+			"}",
+		)
+	}
+	certFile, keyFile := cs.SSLClientCertificateKeyFile, cs.SSLClientCertificateKeyFile
+	if cs.SSLCertificateFileSet {
+		certFile, keyFile = cs.SSLCertificateFile, cs.SSLPrivateKeyFile
+	}
+	if certFile != "" {
+		if cs.SSLClientCertificateKeyPasswordSet {
+			code = append(code, "// tls.LoadX509KeyPair can't read an encrypted key, so decrypt it first")
+		}
+		code = append(code,
+			fmt.Sprintf("cert, err := tls.LoadX509KeyPair(%q, %q)", certFile, keyFile),
+			"if err != nil {",
+			"\tpanic(err)",
+			"}",
+			"tlsConfig.Certificates = []tls.Certificate{cert}",
+		)
+	}
+	return code
+}
+
+// uriOptions returns the lowercase names of the options in the query string
+// of a connection string.
+func uriOptions(uri string) map[string]bool {
+	names := make(map[string]bool)
+	q := strings.Index(uri, "?")
+	if q < 0 {
+		return names
+	}
+	for _, pair := range strings.FieldsFunc(uri[q+1:], func(r rune) bool { return r == '&' || r == ';' }) {
+		name := strings.SplitN(pair, "=", 2)[0]
+		names[strings.ToLower(name)] = true
+	}
+	return names
+}
+
+// srvHostname returns the hostname of a mongodb+srv connection string
+// without the credentials.
+func srvHostname(uri string) string {
+	rest := uri[strings.Index(uri, "://")+3:]
+	if end := strings.IndexAny(rest, "/?"); end >= 0 {
+		rest = rest[:end]
+	}
+	return rest[strings.LastIndex(rest, "@")+1:]
+}
+
+func goDuration(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "0"
+	case d%time.Second == 0:
+		return fmt.Sprintf("%d * time.Second", d/time.Second)
+	default:
+		return fmt.Sprintf("%d * time.Millisecond", d/time.Millisecond)
+	}
+}
+
+// exportedName converts a read preference mode such as "secondaryPreferred"
+// to the name of its readpref constructor, such as "SecondaryPreferred".
+func exportedName(mode string) string {
+	if mode == "" {
+		return mode
+	}
+	return strings.ToUpper(mode[:1]) + mode[1:]
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func boolValue(b *bool) string {
+	if b == nil {
+		return ""
+	}
+	return fmt.Sprint(*b)
+}
+
+func intValue(i *int) string {
+	if i == nil {
+		return ""
+	}
+	return fmt.Sprint(*i)
+}
+
+func uintValue(u *uint64) string {
+	if u == nil {
+		return ""
+	}
+	return fmt.Sprint(*u)
+}
+
+func durationValue(d *time.Duration) string {
+	if d == nil {
+		return ""
+	}
+	return d.String()
+}
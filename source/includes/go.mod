@@ -3,6 +3,6 @@ module includes
 go 1.16
 
 require (
-	github.com/joho/godotenv v1.3.0 // indirect
-	go.mongodb.org/mongo-driver v1.7.0 // indirect
+	github.com/joho/godotenv v1.3.0
+	go.mongodb.org/mongo-driver v1.11.6
 )